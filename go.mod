@@ -1,5 +1,5 @@
 module vsrevisited
 
-go 1.20
+go 1.21
 
 require github.com/linkdotnet/golang-stringbuilder v0.10.0
@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// aclPermission grants read and/or write access to every key starting with prefix. An empty prefix covers every key.
+type aclPermission struct {
+	prefix string
+	read   bool
+	write  bool
+}
+
+// AccessControl is the replicated state of users & roles. It is changed through acl operations in the log:
+// - acl user set name token [role ...]: creates or replaces a user authenticated by token
+// - acl user delete name
+// - acl role set name [read|write|readwrite prefix ...]: creates or replaces a role, where * as prefix covers every key
+// - acl role delete name
+// - acl list: lists every user with its roles & every role with its permissions
+// Access control is disabled until the first user is created. From then on, every client request must carry the
+// token of a user whose roles permit the request. The ADMIN_ROLE permits every request including acl operations.
+// Only sha256 hashes of tokens are kept, as the primary hashes the token of acl user set before recording it in the log.
+type AccessControl struct {
+	users  map[string][]string
+	tokens map[string]string
+	roles  map[string][]aclPermission
+	mu     sync.Mutex
+}
+
+// NewAccessControl creates a new instance of AccessControl without any users or roles
+func NewAccessControl() *AccessControl {
+	return &AccessControl{
+		users:  make(map[string][]string),
+		tokens: make(map[string]string),
+		roles:  make(map[string][]aclPermission),
+		mu:     sync.Mutex{},
+	}
+}
+
+// isAclOperation returns true for operations which are applied on the AccessControl instead of the database
+func isAclOperation(operation string) bool {
+	fields := strings.Fields(operation)
+	return len(fields) > 0 && fields[0] == ACL_REQUEST
+}
+
+// hashToken returns the hex encoded sha256 hash of a token
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// PrepareOperation rewrites an operation before it is recorded in the log, so that the log carries the hash of the
+// token of acl user set instead of the token itself. Other operations are returned as is.
+func (acl *AccessControl) PrepareOperation(operation string) string {
+	fields := strings.Fields(operation)
+	if len(fields) >= 5 && fields[0] == ACL_REQUEST && fields[1] == "user" && fields[2] == "set" {
+		fields[4] = hashToken(fields[4])
+		return strings.Join(fields, " ")
+	}
+	return operation
+}
+
+// Authorize returns nil if the user authenticated by token may perform an operation. It returns ErrUnauthenticated if
+// access control is enabled & the token is unknown, or ErrPermissionDenied if the roles of the user do not permit it.
+// leaseKeys returns the keys & names of locks attached to a lease, which an operation on the lease writes.
+// While access control is disabled, the first user may only be created with the ADMIN_ROLE.
+func (acl *AccessControl) Authorize(token string, operation string, leaseKeys func(int) []string) error {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	if len(acl.users) == 0 {
+		fields := strings.Fields(operation)
+		if len(fields) >= 5 && fields[0] == ACL_REQUEST && fields[1] == "user" && fields[2] == "set" && !slices.Contains(fields[5:], ADMIN_ROLE) {
+			return ErrPermissionDenied
+		}
+		return nil
+	}
+	user, exists := acl.tokens[hashToken(token)]
+	if !exists {
+		return ErrUnauthenticated
+	}
+	roles := acl.users[user]
+	for _, role := range roles {
+		if role == ADMIN_ROLE {
+			return nil
+		}
+	}
+	if isAclOperation(operation) {
+		return ErrPermissionDenied
+	}
+	reads, writes := keyAccess(operation, leaseKeys)
+	for _, key := range reads {
+		if !acl.permits(roles, key, false) {
+			return ErrPermissionDenied
+		}
+	}
+	for _, key := range writes {
+		if !acl.permits(roles, key, true) {
+			return ErrPermissionDenied
+		}
+	}
+	return nil
+}
+
+// permits returns true if any of the roles grants access to every key starting with key
+func (acl *AccessControl) permits(roles []string, key string, write bool) bool {
+	for _, role := range roles {
+		for _, permission := range acl.roles[role] {
+			if strings.HasPrefix(key, permission.prefix) && ((write && permission.write) || (!write && permission.read)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyAccess returns the keys an operation reads & writes. A range of keys is represented by the longest prefix shared
+// by every key in the range, e.g. the prefix itself for prefix & the common prefix of the bounds for scan.
+// Names of locks & semaphores are treated as keys. Keeping alive, revoking or expiring a lease writes the keys & locks
+// attached to it as returned by leaseKeys, while granting a lease accesses no key. A watch reads its key or prefix.
+func keyAccess(operation string, leaseKeys func(int) []string) ([]string, []string) {
+	splits := strings.Fields(operation)
+	if len(splits) < 2 {
+		return nil, nil
+	}
+	switch splits[0] {
+	case "get", "exists":
+		return splits[1:2], nil
+	case "set", "delete", "cas", "incr", "append":
+		return nil, splits[1:2]
+	case "mget":
+		return splits[1:], nil
+	case "mset", "expire":
+		writes := make([]string, 0)
+		for i := 1; i < len(splits); i += 2 {
+			writes = append(writes, splits[i])
+		}
+		return nil, writes
+	case "prefix":
+		return splits[1:2], nil
+	case WATCH_REQUEST:
+		return []string{strings.TrimSuffix(splits[1], WATCH_PREFIX_SUFFIX)}, nil
+	case "scan":
+		if len(splits) < 3 || splits[1] == SCAN_UNBOUNDED || splits[2] == SCAN_UNBOUNDED {
+			return []string{""}, nil
+		}
+		return []string{commonPrefix(splits[1], splits[2])}, nil
+	case "lease":
+		if id, err := strconv.Atoi(splits[len(splits)-1]); err == nil && splits[1] != "grant" {
+			return nil, leaseKeys(id)
+		}
+		return nil, nil
+	case "lock", "sem":
+		if len(splits) < 3 {
+			return nil, nil
+		}
+		if splits[1] == "holders" {
+			return splits[2:3], nil
+		}
+		return nil, splits[2:3]
+	case "txn":
+		t, err := parseTxn(splitTxnTokens(splits[1:]))
+		if err != nil {
+			return nil, nil
+		}
+		reads, writes := make([]string, 0), make([]string, 0)
+		for _, condition := range t.conditions {
+			reads = append(reads, condition.key)
+		}
+		for _, op := range append(t.then, t.otherwise...) {
+			writes = append(writes, op.key)
+		}
+		return reads, writes
+	}
+	return nil, nil
+}
+
+func commonPrefix(a string, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+// Apply applies a committed acl operation & returns the encoded result
+func (acl *AccessControl) Apply(operation string) string {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	value, err := acl.apply(strings.Fields(operation))
+	return EncodeResult(value, err)
+}
+
+func (acl *AccessControl) apply(splits []string) (string, error) {
+	if len(splits) == 2 && splits[1] == "list" {
+		return acl.list(), nil
+	}
+	if len(splits) < 4 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	name := splits[3]
+	switch splits[1] + " " + splits[2] {
+	case "user set":
+		if len(splits) < 5 {
+			return "", ErrInvalidDatabaseRequest
+		}
+		acl.deleteUser(name)
+		acl.users[name] = splits[5:]
+		acl.tokens[splits[4]] = name
+		return UPDATE_PERFORMED_SUCCESSFULLY, nil
+	case "user delete":
+		if _, exists := acl.users[name]; !exists {
+			return "", ErrValueDoesNotExist
+		}
+		acl.deleteUser(name)
+		return UPDATE_PERFORMED_SUCCESSFULLY, nil
+	case "role set":
+		if name == ADMIN_ROLE || len(splits)%2 != 0 {
+			return "", ErrInvalidDatabaseRequest
+		}
+		permissions := make([]aclPermission, 0)
+		for i := 4; i < len(splits); i += 2 {
+			permission := aclPermission{prefix: strings.TrimSuffix(splits[i+1], SCAN_UNBOUNDED)}
+			switch splits[i] {
+			case PERMISSION_READ:
+				permission.read = true
+			case PERMISSION_WRITE:
+				permission.write = true
+			case PERMISSION_READWRITE:
+				permission.read, permission.write = true, true
+			default:
+				return "", ErrInvalidDatabaseRequest
+			}
+			permissions = append(permissions, permission)
+		}
+		acl.roles[name] = permissions
+		return UPDATE_PERFORMED_SUCCESSFULLY, nil
+	case "role delete":
+		if _, exists := acl.roles[name]; !exists {
+			return "", ErrValueDoesNotExist
+		}
+		delete(acl.roles, name)
+		return UPDATE_PERFORMED_SUCCESSFULLY, nil
+	}
+	return "", ErrInvalidDatabaseRequest
+}
+
+func (acl *AccessControl) deleteUser(name string) {
+	for token, user := range acl.tokens {
+		if user == name {
+			delete(acl.tokens, token)
+		}
+	}
+	delete(acl.users, name)
+}
+
+// list returns "user name role ..." for every user followed by "role name permission prefix ..." for every role
+func (acl *AccessControl) list() string {
+	lines := make([]string, 0)
+	for _, name := range sortedKeys(acl.users) {
+		lines = append(lines, strings.TrimSpace("user "+name+" "+strings.Join(acl.users[name], " ")))
+	}
+	for _, name := range sortedKeys(acl.roles) {
+		line := "role " + name
+		for _, permission := range acl.roles[name] {
+			kind := PERMISSION_READWRITE
+			if !permission.write {
+				kind = PERMISSION_READ
+			} else if !permission.read {
+				kind = PERMISSION_WRITE
+			}
+			line += " " + kind + " " + permission.prefix + SCAN_UNBOUNDED
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Reset drops every user & role, which disables access control
+func (acl *AccessControl) Reset() {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	acl.users = make(map[string][]string)
+	acl.tokens = make(map[string]string)
+	acl.roles = make(map[string][]aclPermission)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"testing"
+)
+
+// newTestAccessControl creates an admin, a user who reads every key & a user who reads & writes keys starting with app/
+func newTestAccessControl() *AccessControl {
+	acl := NewAccessControl()
+	for _, operation := range []string{
+		"acl user set root admin-token admin",
+		"acl role set reader read *",
+		"acl role set app readwrite app/",
+		"acl user set alice alice-token reader",
+		"acl user set bob bob-token app",
+	} {
+		acl.Apply(acl.PrepareOperation(operation))
+	}
+	return acl
+}
+
+// testLeaseKeys attaches app/x to lease 5 & the key other along with the lock app/lock to lease 6
+func testLeaseKeys(id int) []string {
+	switch id {
+	case 5:
+		return []string{"app/x"}
+	case 6:
+		return []string{"other", "app/lock"}
+	}
+	return []string{}
+}
+
+func TestAuthorize(t *testing.T) {
+	acl := newTestAccessControl()
+	tests := []struct {
+		token     string
+		operation string
+		err       error
+	}{
+		{"unknown", "get a", ErrUnauthenticated},
+		{"admin-token", "set a 1", nil},
+		{"admin-token", "acl user delete alice", nil},
+		{"admin-token", "lease revoke 6", nil},
+		{"alice-token", "get a", nil},
+		{"alice-token", "mget a app/b", nil},
+		{"alice-token", "scan * * 10", nil},
+		{"alice-token", "watch app/*", nil},
+		{"alice-token", "lock holders app/lock", nil},
+		{"alice-token", "set a 1", ErrPermissionDenied},
+		{"alice-token", "acl list", ErrPermissionDenied},
+		{"alice-token", "lease grant 30s", nil},
+		{"alice-token", "lease revoke 5", ErrPermissionDenied},
+		{"alice-token", "lease keepalive 6", ErrPermissionDenied},
+		{"alice-token", "lease expire 5", ErrPermissionDenied},
+		{"alice-token", "lease revoke 7", nil},
+		{"bob-token", "set app/x 1", nil},
+		{"bob-token", "get other", ErrPermissionDenied},
+		{"bob-token", "mset app/a 1 other 2", ErrPermissionDenied},
+		{"bob-token", "scan app/a app/z 10", nil},
+		{"bob-token", "scan a app/z 10", ErrPermissionDenied},
+		{"bob-token", "prefix app/ 10", nil},
+		{"bob-token", "prefix a 10", ErrPermissionDenied},
+		{"bob-token", "lock acquire app/lock 5", nil},
+		{"bob-token", "sem acquire other 2 5", ErrPermissionDenied},
+		{"bob-token", "lease revoke 5", nil},
+		{"bob-token", "lease revoke 6", ErrPermissionDenied},
+		{"bob-token", "txn if eq app/a 1 then put app/b 2", nil},
+		{"bob-token", "txn if eq other 1 then put app/b 2", ErrPermissionDenied},
+		{"bob-token", "txn if eq app/a 1 then put app/b 2 else delete other", ErrPermissionDenied},
+	}
+	for _, test := range tests {
+		if err := acl.Authorize(test.token, test.operation, testLeaseKeys); err != test.err {
+			t.Errorf("Authorize(%q, %q) = %v, want %v", test.token, test.operation, err, test.err)
+		}
+	}
+}
+
+func TestAuthorizeFirstUser(t *testing.T) {
+	acl := NewAccessControl()
+	if err := acl.Authorize("", "acl user set alice alice-token reader", testLeaseKeys); err != ErrPermissionDenied {
+		t.Errorf("first user without the admin role: Authorize = %v, want %v", err, ErrPermissionDenied)
+	}
+	for _, operation := range []string{"get a", "lease revoke 5", "acl user set root admin-token reader admin"} {
+		if err := acl.Authorize("", operation, testLeaseKeys); err != nil {
+			t.Errorf("Authorize(%q) without users = %v, want nil", operation, err)
+		}
+	}
+}
+
+func TestAuthorizeAfterUserDeleted(t *testing.T) {
+	acl := newTestAccessControl()
+	acl.Apply("acl user delete alice")
+	if err := acl.Authorize("alice-token", "get a", testLeaseKeys); err != ErrUnauthenticated {
+		t.Errorf("Authorize with the token of a deleted user = %v, want %v", err, ErrUnauthenticated)
+	}
+	if err := acl.Authorize("bob-token", "get app/a", testLeaseKeys); err != nil {
+		t.Errorf("Authorize of another user = %v, want nil", err)
+	}
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// AdminServer is an HTTP server attached to each replica which exposes its internal state to operators
+type AdminServer struct {
+	httpServer *http.Server
+	state      *ServerState
+	metrics    *Metrics
+	pause      *Pause
+	faults     atomic.Bool
+}
+
+// NewAdminServer creates an instance of AdminServer listening on the given port & reporting on the given ServerState & Metrics.
+// Failures are injected into the replica through the given Pause once fault injection is enabled.
+func NewAdminServer(port int, state *ServerState, metrics *Metrics, pause *Pause) *AdminServer {
+	admin := &AdminServer{
+		state:   state,
+		metrics: metrics,
+		pause:   pause,
+		faults:  atomic.Bool{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", admin.handleStatus)
+	mux.HandleFunc("/metrics", admin.handleMetrics)
+	mux.HandleFunc("/pause", admin.handlePause)
+	admin.httpServer = &http.Server{
+		Addr:    "127.0.0.1:" + strconv.Itoa(port),
+		Handler: mux,
+	}
+	return admin
+}
+
+// Start starts serving admin requests. It blocks until the server is closed.
+func (admin *AdminServer) Start() error {
+	err := admin.httpServer.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// EnableFaultInjection lets anyone who can reach the admin endpoint pause the replica through POST /pause.
+// It is disabled by default.
+func (admin *AdminServer) EnableFaultInjection() {
+	admin.faults.Store(true)
+}
+
+// Close immediately closes the listener of the admin server
+func (admin *AdminServer) Close() error {
+	return admin.httpServer.Close()
+}
+
+func (admin *AdminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(admin.state.GetReplicaStatus())
+}
+
+func (admin *AdminServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	admin.metrics.WriteTo(w, admin.state.GetReplicaStatus())
+}
+
+// handlePause pauses the replica for the duration given by the duration parameter, e.g. POST /pause?duration=10s.
+// It is not found unless fault injection is enabled.
+func (admin *AdminServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !admin.faults.Load() {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil || duration <= 0 {
+		http.Error(w, "duration should be a positive duration, e.g. 10s", http.StatusBadRequest)
+		return
+	}
+	admin.pause.Start(duration)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPauseNeedsFaultInjection(t *testing.T) {
+	admin := NewAdminServer(STARTING_PORT+ADMIN_PORT_OFFSET, NewServerState(STARTING_PORT), NewMetrics(), NewPause())
+	pause := func() int {
+		recorder := httptest.NewRecorder()
+		admin.httpServer.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/pause?duration=10s", nil))
+		return recorder.Code
+	}
+
+	if code := pause(); code != http.StatusNotFound || admin.pause.Active() {
+		t.Errorf("pause without fault injection = %d, paused %t, want %d & not paused", code, admin.pause.Active(), http.StatusNotFound)
+	}
+	admin.EnableFaultInjection()
+	if code := pause(); code != http.StatusNoContent || !admin.pause.Active() {
+		t.Errorf("pause with fault injection = %d, paused %t, want %d & paused", code, admin.pause.Active(), http.StatusNoContent)
+	}
+}
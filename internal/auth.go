@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// AuthKey is a shared key of the cluster along with the id it is referred to by in messages
+type AuthKey struct {
+	Id     string
+	Secret []byte
+}
+
+// AuthError is the reason a message was rejected by an Authenticator
+type AuthError struct {
+	Reason string
+}
+
+func (e *AuthError) Error() string {
+	return "message rejected: " + e.Reason
+}
+
+var (
+	ErrUnsignedMessage = &AuthError{Reason: AUTH_REJECT_UNSIGNED}
+	ErrUnknownKey      = &AuthError{Reason: AUTH_REJECT_UNKNOWN_KEY}
+	ErrInvalidMac      = &AuthError{Reason: AUTH_REJECT_INVALID_MAC}
+)
+
+// Authenticator signs every message with an HMAC of the active key & verifies messages against every known key.
+// A signed message is "<key id>:<hex encoded HMAC-SHA256>:<message>", where the HMAC covers the key id & the message.
+// Keys are rotated by first adding the new key on every node, then making it the active key & finally removing the old one.
+// An Authenticator loaded from a file is reloaded from it by Reload, so that nodes rotate keys without a restart.
+// A nil Authenticator neither signs nor verifies messages.
+// Messages carry no nonce or timestamp, so a captured message may be replayed to a node by anyone on the network.
+type Authenticator struct {
+	mu          sync.Mutex
+	path        string
+	keys        map[string][]byte
+	activeKeyId string
+}
+
+// NewAuthenticator creates an Authenticator which signs with the first of the given keys.
+// It returns an error if no key is given, a key id is invalid or repeated, or a secret is shorter than AUTH_MIN_SECRET_LENGTH.
+func NewAuthenticator(keys []AuthKey) (*Authenticator, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no keys given")
+	}
+	auth := &Authenticator{
+		mu:          sync.Mutex{},
+		keys:        make(map[string][]byte),
+		activeKeyId: keys[0].Id,
+	}
+	for _, key := range keys {
+		if key.Id == "" || strings.ContainsAny(key.Id, DELIMETER+" \t") {
+			return nil, errors.New("invalid key id: " + key.Id)
+		}
+		if _, exists := auth.keys[key.Id]; exists {
+			return nil, errors.New("repeated key id: " + key.Id)
+		}
+		if len(key.Secret) < AUTH_MIN_SECRET_LENGTH {
+			return nil, errors.New("secret of key " + key.Id + " is too short")
+		}
+		auth.keys[key.Id] = key.Secret
+	}
+	return auth, nil
+}
+
+// LoadAuthenticator reads the keys of an Authenticator from a file with one "<key id> <secret>" per line.
+// The key on the first line is the active key. Empty lines & lines starting with '#' are ignored.
+func LoadAuthenticator(path string) (*Authenticator, error) {
+	keys, err := readKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := NewAuthenticator(keys)
+	if err != nil {
+		return nil, err
+	}
+	auth.path = path
+	return auth, nil
+}
+
+// Reload reads the keys of an Authenticator loaded by LoadAuthenticator from its file again.
+// It keeps the current keys & returns an error if the file cannot be read or its keys are invalid.
+func (auth *Authenticator) Reload() error {
+	if auth == nil || auth.path == "" {
+		return errors.New("keys not loaded from a file")
+	}
+	keys, err := readKeys(auth.path)
+	if err != nil {
+		return err
+	}
+	reloaded, err := NewAuthenticator(keys)
+	if err != nil {
+		return err
+	}
+
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	auth.keys, auth.activeKeyId = reloaded.keys, reloaded.activeKeyId
+	return nil
+}
+
+// readKeys reads the keys of a file with one "<key id> <secret>" per line, skipping empty lines & comments
+func readKeys(path string) ([]AuthKey, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make([]AuthKey, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, secret, found := strings.Cut(line, " ")
+		if !found {
+			return nil, errors.New("key without a secret: " + id)
+		}
+		keys = append(keys, AuthKey{Id: id, Secret: []byte(strings.TrimSpace(secret))})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Seal signs a message with the active key
+func (auth *Authenticator) Seal(message string) string {
+	if auth == nil {
+		return message
+	}
+
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	return auth.activeKeyId + DELIMETER + auth.mac(auth.activeKeyId, message) + DELIMETER + message
+}
+
+// Open verifies a signed message & returns the message without its signature.
+// It returns an *AuthError if the message is not signed, is signed with an unknown key or its HMAC does not match.
+func (auth *Authenticator) Open(signed string) (string, error) {
+	if auth == nil {
+		return signed, nil
+	}
+	parts := strings.SplitN(signed, DELIMETER, 3)
+	if len(parts) != 3 {
+		return "", ErrUnsignedMessage
+	}
+
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	if _, exists := auth.keys[parts[0]]; !exists {
+		return "", ErrUnknownKey
+	}
+	expected := auth.mac(parts[0], parts[2])
+	if !hmac.Equal([]byte(parts[1]), []byte(expected)) {
+		return "", ErrInvalidMac
+	}
+	return parts[2], nil
+}
+
+func (auth *Authenticator) mac(keyId string, message string) string {
+	hash := hmac.New(sha256.New, auth.keys[keyId])
+	hash.Write([]byte(keyId + DELIMETER + message))
+	return hex.EncodeToString(hash.Sum(nil))
+}
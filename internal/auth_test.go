@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestAuthenticator creates an Authenticator with a secret derived from each key id, signing with the first one
+func newTestAuthenticator(t *testing.T, ids ...string) *Authenticator {
+	keys := make([]AuthKey, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, AuthKey{Id: id, Secret: []byte("secret-of-key-" + id)})
+	}
+	auth, err := NewAuthenticator(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func TestSealOpen(t *testing.T) {
+	auth := newTestAuthenticator(t, "k1")
+	message := "start_view:1:1:1:set a 1-0-7000"
+	sealed := auth.Seal(message)
+	if !strings.HasPrefix(sealed, "k1"+DELIMETER) {
+		t.Errorf("Seal = %q, want it signed with k1", sealed)
+	}
+	if opened, err := auth.Open(sealed); err != nil || opened != message {
+		t.Errorf("Open(Seal(m)) = %q, %v", opened, err)
+	}
+
+	var disabled *Authenticator
+	if sealed := disabled.Seal(message); sealed != message {
+		t.Errorf("Seal without keys = %q", sealed)
+	}
+	if opened, err := disabled.Open(message); err != nil || opened != message {
+		t.Errorf("Open without keys = %q, %v", opened, err)
+	}
+}
+
+func TestOpenRejectsForgedMessages(t *testing.T) {
+	auth := newTestAuthenticator(t, "k1")
+	parts := strings.SplitN(auth.Seal("commit_message:1:2"), DELIMETER, 3)
+	keyId, mac := parts[0], parts[1]
+
+	if _, err := auth.Open("start_view"); err != ErrUnsignedMessage {
+		t.Errorf("Open of an unsigned message = %v, want %v", err, ErrUnsignedMessage)
+	}
+	// the type of an unsigned message with fields is taken for the key id
+	if _, err := auth.Open("start_view:1:1:1:set a 1-0-7000"); err != ErrUnknownKey {
+		t.Errorf("Open of an unsigned message with fields = %v, want %v", err, ErrUnknownKey)
+	}
+	if _, err := auth.Open("k9" + DELIMETER + mac + DELIMETER + "commit_message:1:2"); err != ErrUnknownKey {
+		t.Errorf("Open with an unknown key = %v, want %v", err, ErrUnknownKey)
+	}
+	// the mac of one message does not sign another
+	if _, err := auth.Open(keyId + DELIMETER + mac + DELIMETER + "commit_message:1:3"); err != ErrInvalidMac {
+		t.Errorf("Open of a tampered message = %v, want %v", err, ErrInvalidMac)
+	}
+	// a key of the same id with another secret belongs to another cluster
+	other, err := NewAuthenticator([]AuthKey{{Id: "k1", Secret: []byte("secret-of-another-cluster")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Open(other.Seal("commit_message:1:2")); err != ErrInvalidMac {
+		t.Errorf("Open of a message of another cluster = %v, want %v", err, ErrInvalidMac)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	// nodes are part way through each step of a rotation from k1 to k2 at any time, so every node of a step must
+	// accept the messages of the nodes of the previous & next steps
+	steps := []*Authenticator{
+		newTestAuthenticator(t, "k1"),
+		newTestAuthenticator(t, "k1", "k2"),
+		newTestAuthenticator(t, "k2", "k1"),
+		newTestAuthenticator(t, "k2"),
+	}
+	for i := 1; i < len(steps); i++ {
+		for _, pair := range [][2]*Authenticator{{steps[i-1], steps[i]}, {steps[i], steps[i-1]}} {
+			if _, err := pair[1].Open(pair[0].Seal("m")); err != nil {
+				t.Errorf("step %d: Open = %v", i, err)
+			}
+		}
+	}
+	if _, err := steps[3].Open(steps[0].Seal("m")); err != ErrUnknownKey {
+		t.Errorf("Open of a message signed with a removed key = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestNewAuthenticatorRejectsInvalidKeys(t *testing.T) {
+	secret := []byte(strings.Repeat("s", AUTH_MIN_SECRET_LENGTH))
+	for _, keys := range [][]AuthKey{
+		{},
+		{{Id: "", Secret: secret}},
+		{{Id: "k" + DELIMETER + "1", Secret: secret}},
+		{{Id: "k1", Secret: secret}, {Id: "k1", Secret: secret}},
+		{{Id: "k1", Secret: secret}, {Id: "k2", Secret: secret[1:]}},
+	} {
+		if _, err := NewAuthenticator(keys); err == nil {
+			t.Errorf("NewAuthenticator(%q) succeeded", keys)
+		}
+	}
+}
+
+func TestLoadAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("# k2 is active\n\nk2 secret-of-key-k2\nk1 secret-of-key-k1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := LoadAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed := auth.Seal("m"); !strings.HasPrefix(sealed, "k2"+DELIMETER) {
+		t.Errorf("Seal = %q, want it signed with k2", sealed)
+	}
+	if _, err := auth.Open(newTestAuthenticator(t, "k1").Seal("m")); err != nil {
+		t.Errorf("Open of a message signed with k1 = %v", err)
+	}
+}
+
+func TestReloadAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeys := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeKeys("k1 secret-of-key-k1\n")
+	auth, err := LoadAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedWithK2 := newTestAuthenticator(t, "k2").Seal("m")
+	if _, err := auth.Open(signedWithK2); err != ErrUnknownKey {
+		t.Errorf("Open of a message signed with k2 = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// messages are sealed & opened by the transport & the replica while the keys are reloaded
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			auth.Open(auth.Seal("m"))
+		}
+	}()
+	writeKeys("k2 secret-of-key-k2\nk1 secret-of-key-k1\n")
+	if err := auth.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if sealed := auth.Seal("m"); !strings.HasPrefix(sealed, "k2"+DELIMETER) {
+		t.Errorf("Seal = %q after the reload, want it signed with k2", sealed)
+	}
+	if _, err := auth.Open(signedWithK2); err != nil {
+		t.Errorf("Open of a message signed with k2 = %v after the reload", err)
+	}
+
+	// the keys are kept when the file has invalid keys
+	writeKeys("k3 short\n")
+	if err := auth.Reload(); err == nil {
+		t.Error("Reload of a short secret succeeded")
+	}
+	if _, err := auth.Open(signedWithK2); err != nil {
+		t.Errorf("Open of a message signed with k2 = %v after a failed reload", err)
+	}
+	if err := newTestAuthenticator(t, "k1").Reload(); err == nil {
+		t.Error("Reload of keys not loaded from a file succeeded")
+	}
+}
@@ -0,0 +1,428 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// BenchConfig configures a benchmark run by RunBench:
+// - Duration: how long the clients send operations
+// - Rate: operations per second sent by all the clients together, or 0 for as many as they can
+// - ReadRatio: fraction of operations which are reads, the others are writes
+// - Keys & Distribution: number of keys & how they are chosen, BENCH_DISTRIBUTION_UNIFORM or BENCH_DISTRIBUTION_ZIPFIAN
+// - ValueSize: length of the values written
+// - FailEvery & FailDuration: if FailEvery is positive, the leader is paused for FailDuration every FailEvery
+// - Timeout: how long an operation waits for its response before it fails, or 0 to wait until the benchmark is drained
+type BenchConfig struct {
+	Duration     time.Duration
+	Rate         int
+	ReadRatio    float64
+	Keys         int
+	Distribution string
+	ValueSize    int
+	FailEvery    time.Duration
+	FailDuration time.Duration
+	Timeout      time.Duration
+}
+
+// Validate returns an error describing the first invalid setting of the config, if any
+func (config BenchConfig) Validate() error {
+	if config.Duration <= 0 {
+		return errors.New("duration should be positive")
+	}
+	if config.Rate < 0 || config.Rate > BENCH_MAX_RATE {
+		return errors.New("rate should be between 0 & " + strconv.Itoa(BENCH_MAX_RATE))
+	}
+	if config.ReadRatio < 0 || config.ReadRatio > 1 {
+		return errors.New("read ratio should be between 0 & 1")
+	}
+	if config.Keys < 1 {
+		return errors.New("number of keys should be at least 1")
+	}
+	if config.Distribution != BENCH_DISTRIBUTION_UNIFORM && config.Distribution != BENCH_DISTRIBUTION_ZIPFIAN {
+		return errors.New("distribution should be " + BENCH_DISTRIBUTION_UNIFORM + " or " + BENCH_DISTRIBUTION_ZIPFIAN)
+	}
+	if config.ValueSize < 1 {
+		return errors.New("value size should be at least 1")
+	}
+	if config.FailEvery > 0 && config.FailDuration <= 0 {
+		return errors.New("fail duration should be positive")
+	}
+	if config.Timeout < 0 {
+		return errors.New("timeout should not be negative")
+	}
+	return nil
+}
+
+// LatencySummary is the distribution of the latencies of one kind of operation
+type LatencySummary struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+	Max   time.Duration
+}
+
+// Failover is a failure of the leader injected during a benchmark. Recovery is the time from the injection until
+// the first operation sent after it completed, or 0 if none completed before the end of the benchmark.
+type Failover struct {
+	Leader   int
+	Injected time.Time
+	Recovery time.Duration
+	Err      error
+}
+
+// BenchReport is the result of a benchmark. Incomplete is the number of clients whose last operation was still
+// waiting for a response BENCH_DRAIN_TIMEOUT milliseconds after the end of the benchmark.
+type BenchReport struct {
+	Clients    int
+	Elapsed    time.Duration
+	Reads      LatencySummary
+	Writes     LatencySummary
+	Errors     map[string]int
+	Failovers  []Failover
+	Incomplete int
+}
+
+// benchSamples are the latencies & errors observed by a single client
+type benchSamples struct {
+	reads  []time.Duration
+	writes []time.Duration
+	errors map[string]int
+	done   bool
+	mu     sync.Mutex
+}
+
+func newBenchSamples() *benchSamples {
+	return &benchSamples{
+		reads:  make([]time.Duration, 0),
+		writes: make([]time.Duration, 0),
+		errors: make(map[string]int),
+		done:   false,
+		mu:     sync.Mutex{},
+	}
+}
+
+// failoverTracker resolves the recovery of injected failovers as operations complete
+type failoverTracker struct {
+	failovers []Failover
+	mu        sync.Mutex
+}
+
+func (tracker *failoverTracker) inject(failover Failover) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.failovers = append(tracker.failovers, failover)
+}
+
+// complete records an operation which was sent at start & completed at end
+func (tracker *failoverTracker) complete(start time.Time, end time.Time) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for i := range tracker.failovers {
+		failover := &tracker.failovers[i]
+		if failover.Err == nil && failover.Recovery == 0 && !start.Before(failover.Injected) {
+			failover.Recovery = end.Sub(failover.Injected)
+		}
+	}
+}
+
+// RunBench runs a benchmark of the cluster with one goroutine per client, each of which sends its next operation as
+// soon as the previous one completes & the rate allows. Before the benchmark starts, every client registers its
+// session. Once the duration is over, it waits up to BENCH_DRAIN_TIMEOUT milliseconds for the last operations,
+// which may never complete if the cluster is unavailable, after which they are abandoned.
+func RunBench(clients []*VsClient, config BenchConfig) BenchReport {
+	for _, client := range clients {
+		executeBenchOperation(context.Background(), client, config, "get "+benchKey(0))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Duration)
+	defer cancel()
+	// operations outlive the benchmark until they are drained
+	operationCtx, abandon := context.WithTimeout(context.Background(), config.Duration+BENCH_DRAIN_TIMEOUT*time.Millisecond)
+	defer abandon()
+
+	tracker := &failoverTracker{failovers: make([]Failover, 0), mu: sync.Mutex{}}
+	var tokens <-chan struct{}
+	if config.Rate > 0 {
+		tokens = rateTokens(ctx, config.Rate)
+	}
+	samples := make([]*benchSamples, len(clients))
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i, client := range clients {
+		samples[i] = newBenchSamples()
+		wg.Add(1)
+		go func(i int, client *VsClient) {
+			defer wg.Done()
+			runBenchClient(ctx, operationCtx, client, config, tokens, rand.New(rand.NewSource(start.UnixNano()+int64(i))), samples[i], tracker)
+		}(i, client)
+	}
+	injected := make(chan struct{})
+	go func() {
+		defer close(injected)
+		if config.FailEvery > 0 {
+			injectFailovers(ctx, config, tracker)
+		}
+	}()
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	<-ctx.Done()
+	<-injected
+	select {
+	case <-drained:
+	case <-time.After(BENCH_DRAIN_TIMEOUT * time.Millisecond):
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	report := BenchReport{
+		Clients:   len(clients),
+		Elapsed:   time.Since(start),
+		Errors:    make(map[string]int),
+		Failovers: append([]Failover{}, tracker.failovers...),
+	}
+	reads, writes := make([]time.Duration, 0), make([]time.Duration, 0)
+	for _, s := range samples {
+		s.mu.Lock()
+		reads = append(reads, s.reads...)
+		writes = append(writes, s.writes...)
+		for code, count := range s.errors {
+			report.Errors[code] += count
+		}
+		if !s.done {
+			report.Incomplete += 1
+		}
+		s.mu.Unlock()
+	}
+	report.Reads, report.Writes = summarizeLatencies(reads), summarizeLatencies(writes)
+	return report
+}
+
+// rateTokens returns a channel which yields rate tokens per second until ctx is done
+func rateTokens(ctx context.Context, rate int) <-chan struct{} {
+	tokens := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(time.Second/time.Duration(rate), time.Nanosecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case tokens <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return tokens
+}
+
+// runBenchClient sends operations until ctx is done, each of which waits for its response until operationCtx is done
+// or it times out
+func runBenchClient(ctx context.Context, operationCtx context.Context, client *VsClient, config BenchConfig, tokens <-chan struct{}, r *rand.Rand, samples *benchSamples, tracker *failoverTracker) {
+	defer func() {
+		samples.mu.Lock()
+		samples.done = true
+		samples.mu.Unlock()
+	}()
+	nextKey := func() int { return r.Intn(config.Keys) }
+	if config.Distribution == BENCH_DISTRIBUTION_ZIPFIAN {
+		zipf := rand.NewZipf(r, BENCH_ZIPF_EXPONENT, 1, uint64(config.Keys-1))
+		nextKey = func() int { return int(zipf.Uint64()) }
+	}
+	value := make([]byte, config.ValueSize)
+	for ctx.Err() == nil {
+		if tokens != nil {
+			select {
+			case <-tokens:
+			case <-ctx.Done():
+				return
+			}
+		}
+		read := r.Float64() < config.ReadRatio
+		operation := "get " + benchKey(nextKey())
+		if !read {
+			for i := range value {
+				value[i] = byte('a' + r.Intn(26))
+			}
+			operation = "set " + benchKey(nextKey()) + " " + string(value)
+		}
+
+		start := time.Now()
+		_, err := executeBenchOperation(operationCtx, client, config, operation)
+		end := time.Now()
+		// reads of keys which were not written yet are as costly as any other read
+		failed := err != nil && err != ErrValueDoesNotExist
+		samples.mu.Lock()
+		if failed {
+			if _, ok := err.(*OperationError); ok {
+				samples.errors[err.Error()] += 1
+			} else if errors.Is(err, context.DeadlineExceeded) {
+				samples.errors[BENCH_ERROR_TIMEOUT] += 1
+			} else {
+				samples.errors[BENCH_ERROR_TRANSPORT] += 1
+			}
+		} else if read {
+			samples.reads = append(samples.reads, end.Sub(start))
+		} else {
+			samples.writes = append(samples.writes, end.Sub(start))
+		}
+		samples.mu.Unlock()
+		if !failed {
+			tracker.complete(start, end)
+		}
+	}
+}
+
+// executeBenchOperation executes an operation which fails once ctx is done or config.Timeout passed
+func executeBenchOperation(ctx context.Context, client *VsClient, config BenchConfig, operation string) (string, error) {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	return client.ExecuteContext(ctx, operation)
+}
+
+// injectFailovers pauses the leader reported by the majority of the replicas every config.FailEvery
+func injectFailovers(ctx context.Context, config BenchConfig, tracker *failoverTracker) {
+	ticker := time.NewTicker(config.FailEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// the ticker may fire along with the end of the benchmark
+			if ctx.Err() != nil {
+				return
+			}
+			leaders := make([]int, 0)
+			for _, member := range FetchClusterStatus() {
+				if member.Err == nil {
+					leaders = append(leaders, member.Status.LeaderPort)
+				}
+			}
+			leader := majority(leaders)
+			tracker.inject(Failover{Leader: leader, Injected: time.Now(), Err: pauseReplica(leader, config.FailDuration)})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pauseReplica pauses a replica through its admin endpoint
+func pauseReplica(port int, duration time.Duration) error {
+	if port == 0 {
+		return errors.New("no replica is reachable")
+	}
+	client := &http.Client{Timeout: ADMIN_TIMEOUT * time.Millisecond}
+	resp, err := client.Post("http://127.0.0.1:"+strconv.Itoa(port+ADMIN_PORT_OFFSET)+"/pause?duration="+duration.String(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func benchKey(n int) string {
+	return BENCH_KEY_PREFIX + strconv.Itoa(n)
+}
+
+// summarizeLatencies sorts latencies & computes their distribution
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	total := time.Duration(0)
+	for _, latency := range latencies {
+		total += latency
+	}
+	return LatencySummary{
+		Count: len(latencies),
+		Mean:  total / time.Duration(len(latencies)),
+		P50:   percentile(latencies, 0.5),
+		P90:   percentile(latencies, 0.9),
+		P99:   percentile(latencies, 0.99),
+		P999:  percentile(latencies, 0.999),
+		Max:   latencies[len(latencies)-1],
+	}
+}
+
+// percentile returns the smallest of sorted latencies which is at least as large as a fraction q of them
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	index := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// PrintBenchReport writes the throughput, a table of latencies per kind of operation, the errors & the failovers of a benchmark
+func PrintBenchReport(w io.Writer, report BenchReport) {
+	operations := report.Reads.Count + report.Writes.Count
+	fmt.Fprintf(w, "clients %d, elapsed %s, operations %d, throughput %.1f ops/s\n",
+		report.Clients, report.Elapsed.Round(time.Millisecond), operations, float64(operations)/report.Elapsed.Seconds())
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tCOUNT\tMEAN\tP50\tP90\tP99\tP99.9\tMAX")
+	for _, row := range []struct {
+		name    string
+		summary LatencySummary
+	}{{"read", report.Reads}, {"write", report.Writes}} {
+		s := row.summary
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", row.name, s.Count,
+			roundLatency(s.Mean), roundLatency(s.P50), roundLatency(s.P90), roundLatency(s.P99), roundLatency(s.P999), roundLatency(s.Max))
+	}
+	tw.Flush()
+
+	if report.Incomplete > 0 {
+		fmt.Fprintf(w, "%d clients were still waiting for a response\n", report.Incomplete)
+	}
+	if len(report.Errors) > 0 {
+		fmt.Fprintln(w, "errors:")
+		for _, code := range sortedKeys(report.Errors) {
+			fmt.Fprintf(w, "  %s %d\n", code, report.Errors[code])
+		}
+	}
+	if len(report.Failovers) > 0 {
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FAILED LEADER\tINJECTED AT\tRECOVERY")
+		for _, failover := range report.Failovers {
+			recovery := "-"
+			if failover.Err != nil {
+				recovery = "injection failed: " + failover.Err.Error()
+			} else if failover.Recovery > 0 {
+				recovery = roundLatency(failover.Recovery)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", failover.Leader, failover.Injected.Format(time.TimeOnly), recovery)
+		}
+		tw.Flush()
+	}
+}
+
+func roundLatency(latency time.Duration) string {
+	return latency.Round(10 * time.Microsecond).String()
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateRate(t *testing.T) {
+	config := BenchConfig{Duration: time.Second, Keys: 1, Distribution: BENCH_DISTRIBUTION_UNIFORM, ValueSize: 1}
+	for rate, valid := range map[int]bool{0: true, 1: true, BENCH_MAX_RATE: true, -1: false, BENCH_MAX_RATE + 1: false} {
+		config.Rate = rate
+		if err := config.Validate(); (err == nil) != valid {
+			t.Errorf("Validate of rate %d = %v", rate, err)
+		}
+	}
+}
+
+func TestBenchOperationsTimeOut(t *testing.T) {
+	// no replica listens, so every operation waits until it times out
+	network := newMemoryNetwork()
+	client := NewVsClient(7000, network.transport(7000), discardLogger(), nil)
+	config := BenchConfig{
+		Duration:     200 * time.Millisecond,
+		Rate:         BENCH_MAX_RATE,
+		Keys:         1,
+		Distribution: BENCH_DISTRIBUTION_UNIFORM,
+		ValueSize:    1,
+		Timeout:      50 * time.Millisecond,
+	}
+	report := RunBench([]*VsClient{client}, config)
+	if report.Errors[BENCH_ERROR_TIMEOUT] == 0 || report.Incomplete != 0 {
+		t.Errorf("errors %v & %d incomplete clients, want only timeouts", report.Errors, report.Incomplete)
+	}
+	if report.Elapsed > config.Duration+time.Second {
+		t.Errorf("benchmark of %s took %s", config.Duration, report.Elapsed)
+	}
+}
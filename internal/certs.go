@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"time"
+)
+
+// GenerateCertificates writes a certificate & key for every replica & for clients on the given ports to dir, signed by
+// the certificate authority in dir. The certificate authority is created first if dir does not have one yet, so that
+// certificates for more clients can be added later. Existing certificates of nodes are replaced.
+func GenerateCertificates(dir string, clientPorts []int) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	ca, caKey, err := loadCertificateAuthority(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		ca, caKey, err = createCertificateAuthority(dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	ports := make([]int, 0, NUMBER_OF_NODES+len(clientPorts))
+	for i := 0; i < NUMBER_OF_NODES; i++ {
+		ports = append(ports, STARTING_PORT+i)
+	}
+	for _, port := range clientPorts {
+		if isReplicaPort(port) {
+			return errors.New("client port " + nodeName(port) + " belongs to a replica")
+		}
+		ports = append(ports, port)
+	}
+	for _, port := range ports {
+		name := nodeName(port)
+		template, key, err := newCertificateTemplate(name)
+		if err != nil {
+			return err
+		}
+		template.DNSNames = []string{name}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		if err := writeCertificate(dir, name, template, ca, key, caKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadCertificateAuthority(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(certPath(dir, TLS_CA_FILE), keyPath(dir, TLS_CA_FILE))
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("key of certificate authority is not an ECDSA key")
+	}
+	return ca, key, nil
+}
+
+func createCertificateAuthority(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	template, key, err := newCertificateTemplate("vsrevisited-ca")
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	if err := writeCertificate(dir, TLS_CA_FILE, template, template, key, key); err != nil {
+		return nil, nil, err
+	}
+	return loadCertificateAuthority(dir)
+}
+
+// newCertificateTemplate creates a new key along with a template of a certificate for it, valid for CERT_VALIDITY_DAYS
+func newCertificateTemplate(name string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(CERT_VALIDITY_DAYS * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, key, nil
+}
+
+// writeCertificate signs a certificate with the key of its parent & writes it along with its key in PEM format
+func writeCertificate(dir string, name string, template *x509.Certificate, parent *x509.Certificate, key *ecdsa.PrivateKey, parentKey *ecdsa.PrivateKey) error {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath(dir, name), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath(dir, name), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600)
+}
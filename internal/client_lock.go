@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Lock is a lock or a slot of a semaphore held by a client. It is held through a lease which is kept alive in the
+// background until the lock is released. If the lease cannot be kept alive, e.g. because the client was partitioned
+// from the cluster for longer than the ttl, the lock is lost & Lost is closed.
+type Lock struct {
+	client  *VsClient
+	name    string
+	lease   int
+	token   int
+	ttl     time.Duration
+	stop    chan struct{}
+	lost    chan struct{}
+	release sync.Once
+}
+
+// AcquireLock acquires a lock through a new lease with the given ttl, which is at least LOCK_MIN_TTL. While the lock
+// is held by another client, it retries every LOCK_RETRY_INTERVAL milliseconds until ctx is done.
+func (client *VsClient) AcquireLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return client.acquire(ctx, name, ttl, func(lease int) string {
+		return "lock acquire " + name + " " + strconv.Itoa(lease)
+	})
+}
+
+// AcquireSemaphore acquires one of limit slots of a semaphore through a new lease with the given ttl, which is at least
+// LOCK_MIN_TTL. While all the slots are held by other clients, it retries every LOCK_RETRY_INTERVAL milliseconds until
+// ctx is done.
+func (client *VsClient) AcquireSemaphore(ctx context.Context, name string, limit int, ttl time.Duration) (*Lock, error) {
+	return client.acquire(ctx, name, ttl, func(lease int) string {
+		return "sem acquire " + name + " " + strconv.Itoa(limit) + " " + strconv.Itoa(lease)
+	})
+}
+
+// acquire grants a lease & acquires a lock through it. The ttl of the lease runs from the time its grant or keepalive
+// was sent at the latest, which is when the ttl starts for the keepalives of the lock.
+func (client *VsClient) acquire(ctx context.Context, name string, ttl time.Duration, acquireOperation func(lease int) string) (*Lock, error) {
+	if ttl < LOCK_MIN_TTL*time.Millisecond {
+		return nil, ErrInvalidDatabaseRequest
+	}
+	renewed := time.Now()
+	response, err := client.ExecuteContext(ctx, "lease grant "+ttl.String())
+	if err != nil {
+		return nil, err
+	}
+	lease, err := strconv.Atoi(response)
+	if err != nil {
+		return nil, ErrMalformedResponse
+	}
+
+	for {
+		response, err = client.ExecuteContext(ctx, acquireOperation(lease))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrLockHeld) {
+			client.revoke(lease, ttl)
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			client.revoke(lease, ttl)
+			return nil, ctx.Err()
+		case <-time.After(LOCK_RETRY_INTERVAL * time.Millisecond):
+		}
+		// keep the lease alive while waiting
+		sent := time.Now()
+		if _, err := client.ExecuteContext(ctx, "lease keepalive "+strconv.Itoa(lease)); err != nil {
+			client.revoke(lease, ttl)
+			return nil, err
+		}
+		renewed = sent
+	}
+
+	token, err := strconv.Atoi(response)
+	if err != nil {
+		client.revoke(lease, ttl)
+		return nil, ErrMalformedResponse
+	}
+	lock := &Lock{
+		client:  client,
+		name:    name,
+		lease:   lease,
+		token:   token,
+		ttl:     ttl,
+		stop:    make(chan struct{}),
+		lost:    make(chan struct{}),
+		release: sync.Once{},
+	}
+	go lock.keepAlive(renewed)
+	return lock, nil
+}
+
+// revoke revokes a lease, giving up once its ttl has passed as the lease has expired by then
+func (client *VsClient) revoke(lease int, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ttl)
+	defer cancel()
+
+	_, err := client.ExecuteContext(ctx, "lease revoke "+strconv.Itoa(lease))
+	return err
+}
+
+// Token returns the fencing token of the lock. Tokens increase every time a lock is acquired, so a service
+// protected by the lock can reject requests carrying a token older than one it has already seen.
+func (lock *Lock) Token() int {
+	return lock.token
+}
+
+// Lost returns a channel which is closed if the lease of the lock expires before the lock is released
+func (lock *Lock) Lost() <-chan struct{} {
+	return lock.lost
+}
+
+// Release releases the lock & revokes its lease. Calls after the first one do nothing & return nil.
+func (lock *Lock) Release() error {
+	var err error
+	lock.release.Do(func() {
+		close(lock.stop)
+		err = lock.client.revoke(lock.lease, lock.ttl)
+	})
+	return err
+}
+
+// keepAlive keeps the lease alive every third of its ttl, starting from the time renewed at which it was last kept
+// alive. Each keepalive gives up once the ttl has passed since the last successful one, at which point the lease may
+// have expired & the lock is lost.
+func (lock *Lock) keepAlive(renewed time.Time) {
+	ticker := time.NewTicker(lock.ttl / 3)
+	defer ticker.Stop()
+	expiry := time.NewTimer(time.Until(renewed.Add(lock.ttl)))
+	defer expiry.Stop()
+	results := make(chan error, 1)
+	sent, pending := time.Time{}, false
+	for {
+		select {
+		case <-ticker.C:
+			if pending {
+				continue
+			}
+			sent, pending = time.Now(), true
+			go func(deadline time.Time) {
+				ctx, cancel := context.WithDeadline(context.Background(), deadline)
+				defer cancel()
+				_, err := lock.client.ExecuteContext(ctx, "lease keepalive "+strconv.Itoa(lock.lease))
+				results <- err
+			}(renewed.Add(lock.ttl))
+		case err := <-results:
+			pending = false
+			if errors.Is(err, ErrLeaseNotFound) {
+				lock.markLost()
+				return
+			}
+			if err == nil {
+				renewed = sent
+				if !expiry.Stop() {
+					<-expiry.C
+				}
+				expiry.Reset(time.Until(renewed.Add(lock.ttl)))
+			}
+		case <-expiry.C:
+			lock.markLost()
+			return
+		case <-lock.stop:
+			return
+		}
+	}
+}
+
+func (lock *Lock) markLost() {
+	lock.client.logger.Warn("lock lost", "lock", lock.name, "lease", lock.lease)
+	close(lock.lost)
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockIsLostOnceKeepalivesTimeOut(t *testing.T) {
+	network := newMemoryNetwork()
+	partitioned := atomic.Bool{}
+	fakeLeader(t, network, func(operation string) (string, error, bool) {
+		switch {
+		case operation == REGISTER_REQUEST:
+			return "65536", nil, true
+		case strings.HasPrefix(operation, "lease grant"), strings.HasPrefix(operation, "lock acquire"):
+			return "1", nil, true
+		default:
+			return "", nil, !partitioned.Load()
+		}
+	})
+	client := newTestClient(t, network)
+
+	ttl := LOCK_MIN_TTL * time.Millisecond
+	lock, err := client.AcquireLock(context.Background(), "l", ttl)
+	if err != nil {
+		t.Fatalf("AcquireLock: %v", err)
+	}
+	// the lock is kept alive while the leader answers keepalives
+	select {
+	case <-lock.Lost():
+		t.Fatal("lock lost while keepalives succeed")
+	case <-time.After(2 * ttl):
+	}
+
+	partitioned.Store(true)
+	select {
+	case <-lock.Lost():
+	case <-time.After(2 * ttl):
+		t.Fatal("lock not lost after its ttl passed without a keepalive")
+	}
+}
+
+func TestReleaseTwice(t *testing.T) {
+	network := newMemoryNetwork()
+	fakeLeader(t, network, func(operation string) (string, error, bool) {
+		if operation == REGISTER_REQUEST {
+			return "65536", nil, true
+		}
+		return "1", nil, true
+	})
+	client := newTestClient(t, network)
+
+	lock, err := client.AcquireLock(context.Background(), "l", LOCK_MIN_TTL*time.Millisecond)
+	if err != nil {
+		t.Fatalf("AcquireLock: %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("first Release: %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("second Release: %v", err)
+	}
+}
+
+func TestAcquireRejectsInvalidResponses(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		lease string
+		token string
+		want  error
+	}{
+		{name: "ttl below minimum", ttl: 2, lease: "1", token: "1", want: ErrInvalidDatabaseRequest},
+		{name: "non numeric lease", ttl: LOCK_MIN_TTL * time.Millisecond, lease: "x", token: "1", want: ErrMalformedResponse},
+		{name: "non numeric token", ttl: LOCK_MIN_TTL * time.Millisecond, lease: "1", token: "x", want: ErrMalformedResponse},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			network := newMemoryNetwork()
+			revoked := make(chan string, 1)
+			fakeLeader(t, network, func(operation string) (string, error, bool) {
+				switch {
+				case operation == REGISTER_REQUEST:
+					return "65536", nil, true
+				case strings.HasPrefix(operation, "lease grant"):
+					return test.lease, nil, true
+				case strings.HasPrefix(operation, "lease revoke"):
+					revoked <- operation
+					return "", nil, true
+				default:
+					return test.token, nil, true
+				}
+			})
+			client := newTestClient(t, network)
+
+			lock, err := client.AcquireLock(context.Background(), "l", test.ttl)
+			if !errors.Is(err, test.want) || lock != nil {
+				t.Fatalf("AcquireLock = %v, %v, want %v", lock, err, test.want)
+			}
+			// a lease granted for a lock which could not be acquired is revoked
+			if test.token == "x" {
+				if operation := <-revoked; operation != "lease revoke 1" {
+					t.Errorf("revoked with %q, want lease revoke 1", operation)
+				}
+			}
+		})
+	}
+}
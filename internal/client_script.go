@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// clientResult is the result of an operation as printed in OUTPUT_FORMAT_JSON
+type clientResult struct {
+	Operation string `json:"operation"`
+	Ok        bool   `json:"ok"`
+	Value     string `json:"value,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// clientSummary is the summary of a batch as printed in OUTPUT_FORMAT_JSON
+type clientSummary struct {
+	Operations int `json:"operations"`
+	Succeeded  int `json:"succeeded"`
+	Failed     int `json:"failed"`
+}
+
+// clientWatchEvent is a change of a watched key as printed in OUTPUT_FORMAT_JSON
+type clientWatchEvent struct {
+	Event string `json:"event"`
+}
+
+// SetOutputFormat sets the format in which results are printed, OUTPUT_FORMAT_TEXT or OUTPUT_FORMAT_JSON.
+// JSON results are printed one object per line.
+func (client *VsClient) SetOutputFormat(format string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.format = format
+}
+
+// SetTimeout sets how long the operations run from input, Exec or ExecBatch wait for their response, after which they
+// fail with context.DeadlineExceeded. A timeout of 0 waits until a response is received.
+func (client *VsClient) SetTimeout(timeout time.Duration) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.timeout = timeout
+}
+
+// Exec executes a single operation & prints its result. It returns true if the operation succeeded.
+// A watch then prints the changes it receives until the client is stopped.
+func (client *VsClient) Exec(operation string) bool {
+	return client.run(operation, true)
+}
+
+// ExecBatch executes operations in order & prints the result of each followed by a summary. A failed operation does
+// not stop the batch. Watches are registered but the changes they receive are not printed.
+// It returns the number of operations which failed.
+func (client *VsClient) ExecBatch(operations []string) int {
+	failed := 0
+	for _, operation := range operations {
+		if !client.run(operation, false) {
+			failed += 1
+		}
+	}
+	summary := clientSummary{Operations: len(operations), Succeeded: len(operations) - failed, Failed: failed}
+	if client.format == OUTPUT_FORMAT_JSON {
+		json.NewEncoder(client.output).Encode(summary)
+	} else {
+		fmt.Fprintf(client.output, "[summary] %d operations, %d succeeded, %d failed\n", summary.Operations, summary.Succeeded, summary.Failed)
+	}
+	return failed
+}
+
+// run executes an operation within the timeout of the client, prints its result & then the changes received by a
+// watch if watch is true. It returns true if the operation succeeded.
+func (client *VsClient) run(operation string, watch bool) bool {
+	ctx := context.Background()
+	if client.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeout)
+		defer cancel()
+	}
+	response, err := client.ExecuteContext(ctx, operation)
+	client.printResult(operation, response, err)
+
+	if watchRequest, parseErr := ParseWatchRequest(operation); watch && parseErr == nil && err == nil {
+		client.watch(watchRequest, response)
+	}
+	return err == nil
+}
+
+func (client *VsClient) printResult(operation string, response string, err error) {
+	if client.format == OUTPUT_FORMAT_JSON {
+		result := clientResult{Operation: operation, Ok: err == nil, Value: response}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		json.NewEncoder(client.output).Encode(result)
+		return
+	}
+	if _, ok := err.(*OperationError); ok {
+		fmt.Fprintln(client.output, "[server_error] "+err.Error())
+	} else if err != nil {
+		client.logger.Error("error while receiving response", "error", err)
+	} else {
+		fmt.Fprintln(client.output, "[server_response] "+response)
+	}
+}
+
+func (client *VsClient) printWatchEvent(event string) {
+	if client.format == OUTPUT_FORMAT_JSON {
+		json.NewEncoder(client.output).Encode(clientWatchEvent{Event: event})
+		return
+	}
+	fmt.Fprintln(client.output, "[watch_event] "+event)
+}
+
+// ReadOperations reads the operations of a batch from a file, one per line. Empty lines & lines starting with
+// COMMENT_PREFIX are skipped.
+func ReadOperations(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	operations := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, COMMENT_PREFIX) {
+			operations = append(operations, line)
+		}
+	}
+	return operations, scanner.Err()
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"strconv"
+	"time"
 
 	Text "github.com/linkdotnet/golang-stringbuilder"
 )
@@ -9,11 +10,15 @@ import (
 // ClientState struct consists of the state that is maintained on the client side. It consists of:
 // - configuration: Sorted array containing ports of all replicas
 // - clientId: id associated with the client. In this implementation, we are using the port as clientId
+// - sessionId: id of the session registered by the client, which is 0 until the client registers
+// - token: token of the user the client acts as, which is empty unless access control is enabled on the cluster
 // - currentViewNumber: used to track the primary replica
 // - currentRequestNumber: A monotonically increasing integer that is associated with each client request
 type ClientState struct {
 	configuration        []int
 	clientId             int
+	sessionId            int
+	token                string
 	currentViewNumber    int
 	currentRequestNumber int
 }
@@ -27,6 +32,8 @@ func NewClientState(port int) *ClientState {
 	return &ClientState{
 		configuration:        configuration[:],
 		clientId:             port,
+		sessionId:            0,
+		token:                "",
 		currentViewNumber:    0,
 		currentRequestNumber: 0,
 	}
@@ -37,6 +44,10 @@ func (state *ClientState) BuildClientRequest(input string) string {
 	sb := Text.StringBuilder{}
 
 	sb.Append(CLIENT_REQUEST_PREFIX).
+		Append(DELIMETER).
+		AppendInt(state.sessionId).
+		Append(DELIMETER).
+		Append(state.token).
 		Append(DELIMETER).
 		Append(input).
 		Append(DELIMETER).
@@ -47,10 +58,44 @@ func (state *ClientState) BuildClientRequest(input string) string {
 	return sb.ToString()
 }
 
+// BuildRegisterRequest creates a request registering a new session. Registrations are numbered by the time they are
+// sent, so that a client restarted on the same port is not given the session of its previous run.
+func (state *ClientState) BuildRegisterRequest() string {
+	sb := Text.StringBuilder{}
+
+	return sb.Append(CLIENT_REQUEST_PREFIX).
+		Append(DELIMETER).
+		AppendInt(0).
+		Append(DELIMETER).
+		Append(state.token).
+		Append(DELIMETER).
+		Append(REGISTER_REQUEST).
+		Append(DELIMETER).
+		AppendInt(int(time.Now().UnixMilli())).
+		ToString()
+}
+
+// RecordSessionId records the id of the session registered by the client & restarts its request numbers.
+// It is reset to 0 once the session expires.
+func (state *ClientState) RecordSessionId(sessionId int) {
+	state.sessionId = sessionId
+	state.currentRequestNumber = 0
+}
+
+// SetToken sets the token sent along with every request of the client
+func (state *ClientState) SetToken(token string) {
+	state.token = token
+}
+
+// GetSessionId returns the id of the session registered by the client, or 0 if it has none
+func (state *ClientState) GetSessionId() int {
+	return state.sessionId
+}
+
 // Broadcast sends a UDP message to all the replica nodes
-func (state *ClientState) Broadcast(clientRequest string, udpHandler *UdpHandler) {
+func (state *ClientState) Broadcast(clientRequest string, transport Transport) {
 	for i := 0; i < NUMBER_OF_NODES; i++ {
-		udpHandler.Send(clientRequest, STARTING_PORT+i)
+		transport.Send(clientRequest, STARTING_PORT+i)
 	}
 }
 
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// ClusterMemberStatus is the status reported by a single replica along with the error, if any, while querying it
+type ClusterMemberStatus struct {
+	Port   int
+	Status ReplicaStatus
+	Err    error
+}
+
+// FetchClusterStatus queries the admin endpoint of every replica in the configuration.
+// Replicas that cannot be reached are returned with a non-nil Err.
+func FetchClusterStatus() []ClusterMemberStatus {
+	client := &http.Client{Timeout: ADMIN_TIMEOUT * time.Millisecond}
+	members := make([]ClusterMemberStatus, NUMBER_OF_NODES)
+	for i := 0; i < NUMBER_OF_NODES; i++ {
+		port := STARTING_PORT + i
+		members[i].Port = port
+		members[i].Status, members[i].Err = fetchReplicaStatus(client, port)
+	}
+	return members
+}
+
+func fetchReplicaStatus(client *http.Client, port int) (ReplicaStatus, error) {
+	resp, err := client.Get("http://127.0.0.1:" + strconv.Itoa(port+ADMIN_PORT_OFFSET) + "/status")
+	if err != nil {
+		return ReplicaStatus{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ReplicaStatus{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	var status ReplicaStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
+
+// PrintClusterStatus writes a table with the state of every replica.
+// Values which differ from the majority of reachable replicas are marked with '*'.
+func PrintClusterStatus(w io.Writer, members []ClusterMemberStatus) {
+	views := make([]int, 0)
+	leaders := make([]int, 0)
+	commits := make([]int, 0)
+	for _, member := range members {
+		if member.Err == nil {
+			views = append(views, member.Status.ViewNumber)
+			leaders = append(leaders, member.Status.LeaderPort)
+			commits = append(commits, member.Status.CommitNumber)
+		}
+	}
+	majorityView, majorityLeader, majorityCommit := majority(views), majority(leaders), majority(commits)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PORT\tSTATUS\tVIEW\tLEADER\tOP\tCOMMIT\tLOG\tDIGEST")
+	diverged := false
+	for _, member := range members {
+		if member.Err != nil {
+			fmt.Fprintf(tw, "%d\tunreachable\t-\t-\t-\t-\t-\t-\n", member.Port)
+			continue
+		}
+		status := member.Status
+		view := markDivergence(status.ViewNumber, majorityView, &diverged)
+		leader := markDivergence(status.LeaderPort, majorityLeader, &diverged)
+		commit := markDivergence(status.CommitNumber, majorityCommit, &diverged)
+		digest := status.Digest
+		if digest == "" {
+			digest = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\t%d\t%s\n",
+			member.Port, status.Status, view, leader, status.OperationNumber, commit, status.LogLength, digest)
+	}
+	tw.Flush()
+	if diverged {
+		fmt.Fprintln(w, "* differs from the majority of reachable replicas")
+	}
+}
+
+func markDivergence(value int, majorityValue int, diverged *bool) string {
+	if value != majorityValue {
+		*diverged = true
+		return strconv.Itoa(value) + "*"
+	}
+	return strconv.Itoa(value)
+}
+
+// majority returns the most frequent value in values. Ties are broken in favour of the larger value.
+func majority(values []int) int {
+	counts := make(map[int]int)
+	result, resultCount := 0, 0
+	for _, v := range values {
+		counts[v] += 1
+		if counts[v] > resultCount || (counts[v] == resultCount && v > result) {
+			result, resultCount = v, counts[v]
+		}
+	}
+	return result
+}
@@ -5,13 +5,31 @@ const (
 	NUMBER_OF_NODES = 5
 	STARTING_PORT   = 8000
 
+	// admin endpoint of a replica listens on its port + ADMIN_PORT_OFFSET
+	ADMIN_PORT_OFFSET = 1000
+	ADMIN_TIMEOUT     = 500
+
+	// mutations of ServerState which are checked against the safety invariants in builds with the vsrdebug tag
+	MUTATION_RECORD_REQUEST      = "RecordRequest"
+	MUTATION_RECORD_COMMIT       = "RecordCommit"
+	MUTATION_UPDATE_FOR_NEW_VIEW = "UpdateForNewView"
+	MUTATION_UPDATE_VIEW         = "UpdateView"
+	MUTATION_TRUNCATE_LOG        = "TruncateLog"
+
 	// constants for request
 	DELIMETER                                  = ":"
 	LOG_DELIMETER                              = "-"
+	LOGS_DELIMETER                             = ","
 	CLIENT_REQUEST_PREFIX                      = "client_request"
 	SERVER_RESPONSE_PREFIX                     = "server_response"
 	SERVER_RESPONSE_NON_NUMERIC_REQUEST_NUMBER = "non_numeric_request_number"
 	SERVER_RESPONSE_INVALID_REQUEST_NUMER      = "invalid_request_number"
+	SERVER_RESPONSE_MALFORMED                  = "malformed_server_response"
+	SERVER_RESPONSE_SESSION_REQUIRED           = "session_required"
+	SERVER_RESPONSE_SESSION_EXPIRED            = "session_expired"
+	SERVER_RESPONSE_UNAUTHENTICATED            = "unauthenticated"
+	SERVER_RESPONSE_PERMISSION_DENIED          = "permission_denied"
+	SERVER_RESPONSE_MALFORMED_REQUEST          = "malformed_request"
 	PREPARE_REQUEST_PREFIX                     = "prepare_request"
 	PREPARE_RESPONSE_PREFIX                    = "prepare_response"
 	COMMIT_MESSAGE_PREFIX                      = "commit_message"
@@ -20,16 +38,145 @@ const (
 	START_VIEW_CHANGE_PREFIX                   = "start_view_change"
 	DO_VIEW_CHANGE_PREFIX                      = "do_view_change"
 	START_VIEW_PREFIX                          = "start_view"
+	WATCH_EVENT_PREFIX                         = "watch_event"
 
 	// database operation status
 	INVALID_DATABASE_REQUEST      = "invalid_database_request"
 	VALUE_DOES_NOT_EXIST          = "value_does_not_exist"
+	VALUE_NOT_AN_INTEGER          = "value_not_an_integer"
+	INTEGER_OVERFLOW              = "integer_overflow"
+	COMPARE_FAILED                = "compare_failed"
+	REVISION_COMPACTED            = "revision_compacted"
+	LEASE_NOT_FOUND               = "lease_not_found"
+	LOCK_HELD                     = "lock_held"
+	LOCK_NOT_HELD                 = "lock_not_held"
 	UPDATE_PERFORMED_SUCCESSFULLY = "update_performed_successfully"
 
+	// transaction keywords & the branch reported as result of a transaction
+	TXN_IF        = "if"
+	TXN_AND       = "and"
+	TXN_THEN      = "then"
+	TXN_ELSE      = "else"
+	TXN_SEPARATOR = ";"
+
+	// range scans. SCAN_UNBOUNDED as start or end of a scan stands for the first or last key.
+	// Results are cut short so that a response fits in a single datagram.
+	SCAN_UNBOUNDED           = "*"
+	SCAN_MAX_RESPONSE_LENGTH = 768
+
+	// revisions are written with REVISION_PREFIX, e.g. "get key @5". HISTORY_LENGTH updates are retained per key.
+	REVISION_PREFIX = "@"
+	HISTORY_LENGTH  = 16
+
+	// options of set for the ttl & the lease of a key. The leader checks for expired keys & leases
+	// every EXPIRY_CHECK_INTERVAL milliseconds & expires at most EXPIRY_BATCH_SIZE keys in one operation.
+	TTL_OPTION            = "ttl="
+	LEASE_OPTION          = "lease="
+	EXPIRY_CHECK_INTERVAL = 1000
+	EXPIRY_BATCH_SIZE     = 20
+
+	// clients waiting for a lock retry every LOCK_RETRY_INTERVAL milliseconds. The lease of a lock has a ttl of at least
+	// LOCK_MIN_TTL milliseconds, as it is kept alive every third of its ttl.
+	LOCK_RETRY_INTERVAL = 500
+	LOCK_MIN_TTL        = 1000
+
+	// changes of keys. CHANGE_LOG_LENGTH changes across all keys are retained for watchers to resume from.
+	CHANGE_PUT        = "put"
+	CHANGE_DELETE     = "delete"
+	CHANGE_LOG_LENGTH = 1024
+
+	// watches. Clients renew their watch every WATCH_RENEW_INTERVAL milliseconds & the leader drops watches
+	// which are not renewed within WATCH_EXPIRY milliseconds. A key ending with WATCH_PREFIX_SUFFIX watches a prefix.
+	WATCH_REQUEST        = "watch"
+	UNWATCH_REQUEST      = "unwatch"
+	WATCH_REGISTERED     = "watching"
+	WATCH_PREFIX_SUFFIX  = "*"
+	WATCH_RENEW_INTERVAL = 3000
+	WATCH_EXPIRY         = 10000
+
+	// client sessions. A client registers with session id 0 & sends every other request with its session id.
+	// Ids of sessions start at SESSION_ID_OFFSET. The leader expires sessions which are idle for SESSION_TIMEOUT milliseconds.
+	REGISTER_REQUEST  = "register"
+	SESSION_REQUEST   = "session"
+	SESSION_ID_OFFSET = 65536
+	SESSION_TIMEOUT   = 60000
+
+	// malformed messages are rejected by one of the PARSE reasons
+	PARSE_UNKNOWN_TYPE   = "unknown_type"
+	PARSE_FIELD_COUNT    = "field_count"
+	PARSE_INVALID_NUMBER = "invalid_number"
+	PARSE_INVALID_LOG    = "invalid_log"
+	PARSE_INCONSISTENT   = "inconsistent"
+
+	// benchmarks. Keys are named BENCH_KEY_PREFIX followed by their number & are drawn uniformly or from a zipfian
+	// distribution with exponent BENCH_ZIPF_EXPONENT. Failed requests which are not an OperationError are counted
+	// as BENCH_ERROR_TIMEOUT if they timed out & as BENCH_ERROR_TRANSPORT otherwise. Operations which are still
+	// waiting for a response BENCH_DRAIN_TIMEOUT milliseconds after the end of a benchmark are abandoned.
+	// Rates above BENCH_MAX_RATE operations per second cannot be paced by a ticker.
+	BENCH_KEY_PREFIX           = "bench-"
+	BENCH_DISTRIBUTION_UNIFORM = "uniform"
+	BENCH_DISTRIBUTION_ZIPFIAN = "zipfian"
+	BENCH_ZIPF_EXPONENT        = 1.1
+	BENCH_ERROR_TRANSPORT      = "transport"
+	BENCH_ERROR_TIMEOUT        = "timeout"
+	BENCH_MAX_RATE             = 1000000000
+	BENCH_DRAIN_TIMEOUT        = 5000
+
+	// access control. Roles grant PERMISSION_READ, PERMISSION_WRITE or PERMISSION_READWRITE on prefixes of keys,
+	// while ADMIN_ROLE grants every permission along with managing users & roles.
+	ACL_REQUEST          = "acl"
+	ADMIN_ROLE           = "admin"
+	PERMISSION_READ      = "read"
+	PERMISSION_WRITE     = "write"
+	PERMISSION_READWRITE = "readwrite"
+
+	// every response to a client starts with one of the result types
+	RESULT_OK    = "ok"
+	RESULT_ERROR = "error"
+
+	// the leader sends its commit number along with the digest of the committed operations every HEARTBEAT_INTERVAL
+	// milliseconds. Digests are cut to DIGEST_LENGTH hex characters.
+	HEARTBEAT_INTERVAL = 2000
+	DIGEST_LENGTH      = 16
+
+	// message authentication. Secrets of keys have at least AUTH_MIN_SECRET_LENGTH bytes.
+	// Rejected messages are counted by one of the AUTH_REJECT reasons.
+	AUTH_MIN_SECRET_LENGTH  = 16
+	AUTH_REJECT_UNSIGNED    = "unsigned"
+	AUTH_REJECT_UNKNOWN_KEY = "unknown_key"
+	AUTH_REJECT_INVALID_MAC = "invalid_mac"
+
+	// reasons for rejecting messages from an unexpected sender
+	REJECT_NON_MEMBER     = "non_member"
+	REJECT_NOT_PRIMARY    = "not_primary"
+	REJECT_REPLICA_CLIENT = "replica_client"
+
+	// UDP transport. A datagram carries a single message, which is read whole.
+	UDP_MAX_DATAGRAM_LENGTH = 65535
+
+	// TLS transport. Certificates are named REPLICA_NAME_PREFIX or CLIENT_NAME_PREFIX followed by the port of the node
+	// & are signed by the certificate authority in TLS_CA_FILE. Messages are framed by their length in 4 bytes.
+	REPLICA_NAME_PREFIX   = "replica-"
+	CLIENT_NAME_PREFIX    = "client-"
+	TLS_CA_FILE           = "ca"
+	TLS_MAX_FRAME_LENGTH  = 1 << 20
+	TLS_DIAL_TIMEOUT      = 500
+	TLS_SEND_QUEUE_LENGTH = 256
+	CERT_VALIDITY_DAYS    = 365
+
 	// timeout values associated with server timeout
 	MIN_TIMEOUT = 5001
 	MAX_TIMEOUT = 20000
 
+	// log output formats
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+
+	// client output formats. Lines of a batch of operations starting with COMMENT_PREFIX are skipped.
+	OUTPUT_FORMAT_TEXT = "text"
+	OUTPUT_FORMAT_JSON = "json"
+	COMMENT_PREFIX     = "#"
+
 	// server states
 	NORMAL      = "normal"
 	VIEW_CHANGE = "view change"
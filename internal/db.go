@@ -1,59 +1,279 @@
 package internal
 
 import (
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// Database is the key value store replicated through the log. Keys are kept in order so that they can be scanned.
+// Every key keeps a version which is 1 when the key is created & is incremented on every update of the key,
+// & the revision at which it was last updated. The revision of an operation is the operation number of its log entry.
+// The last HISTORY_LENGTH updates of every key are retained so that past values can be read & the last
+// CHANGE_LOG_LENGTH updates across all keys are retained so that they can be replayed to watchers.
+// The history of a deleted key is dropped once its deletion leaves the change log.
 type Database struct {
-	store map[string]string
-	mu    sync.Mutex
+	store    *skiplist
+	history  map[string][]dbRevision
+	changes  []ChangeEvent
+	leases   map[int]dbLease
+	locks    map[string]*dbLock
+	revision int
+	client   int
+	mu       sync.Mutex
+}
+
+// dbEntry is the value of a key. A key with a ttl is deleted once ttl has passed since its last update & a key
+// attached to a lease is deleted along with the lease.
+type dbEntry struct {
+	value    string
+	version  int
+	revision int
+	ttl      time.Duration
+	lease    int
 }
 
 // NewDatabase creates a new instance of Database struct
 func NewDatabase() *Database {
 	return &Database{
-		store: make(map[string]string),
-		mu:    sync.Mutex{},
+		store:    newSkiplist(),
+		history:  make(map[string][]dbRevision),
+		changes:  make([]ChangeEvent, 0),
+		leases:   make(map[int]dbLease),
+		locks:    make(map[string]*dbLock),
+		revision: 0,
+		client:   0,
+		mu:       sync.Mutex{},
 	}
 }
 
-// PerformOperation is responsible for performing the operation on database.
+// PerformOperation is responsible for performing the operation of a client on database & returns the encoded result for the client.
 // It also is responsible for validating the operation before applying it on the database.
-func (db *Database) PerformOperation(operation string) string {
+func (db *Database) PerformOperation(operation string, revision int, clientId int) string {
+	return EncodeResult(db.ApplyAs(operation, revision, clientId))
+}
+
+// Apply applies an operation at a revision on behalf of no client. It is described in ApplyAs.
+func (db *Database) Apply(operation string, revision int) (string, error) {
+	return db.ApplyAs(operation, revision, 0)
+}
+
+// ApplyAs validates & applies an operation of a client on the database at a revision. Leases granted by the operation
+// are owned by the client & revoked once its session expires. It returns the value produced by the operation,
+// or an *OperationError if the operation is invalid or cannot be applied. Supported operations are:
+// - get key [@revision]
+// - set key value [ttl=duration] [lease=id]
+// - delete key
+// - exists key
+// - cas key expected new
+// - incr key delta
+// - append key suffix
+// - mget key [key ...]
+// - mset key value [key value ...]
+// - scan start end limit
+// - prefix p limit
+// - lease grant|keepalive|revoke|expire ttl|id
+// - expire key @revision [key @revision ...]
+// - lock acquire|release name lease | lock holders name
+// - sem acquire name limit lease | sem release name lease
+// - txn [if condition [and condition ...]] then operation [; operation ...] [else operation [; operation ...]]
+// Values of set & append extend till the end of the operation & may contain spaces.
+func (db *Database) ApplyAs(operation string, revision int, clientId int) (string, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	splits := strings.Split(operation, " ")
+	db.revision, db.client = revision, clientId
+	splits := strings.Fields(operation)
 	if len(splits) == 0 {
-		return INVALID_DATABASE_REQUEST
+		return "", ErrInvalidDatabaseRequest
 	}
-	if splits[0] == "get" {
+	switch splits[0] {
+	case "get":
 		return db.performGet(splits)
-	} else if splits[0] == "set" {
+	case "set":
 		return db.performSet(splits)
-	} else {
-		return INVALID_DATABASE_REQUEST
+	case "delete":
+		return db.performDelete(splits)
+	case "exists":
+		return db.performExists(splits)
+	case "cas":
+		return db.performCas(splits)
+	case "incr":
+		return db.performIncr(splits)
+	case "append":
+		return db.performAppend(splits)
+	case "mget":
+		return db.performMget(splits)
+	case "mset":
+		return db.performMset(splits)
+	case "scan":
+		return db.performScan(splits)
+	case "prefix":
+		return db.performPrefix(splits)
+	case "lease":
+		return db.performLease(splits)
+	case "expire":
+		return db.performExpire(splits)
+	case "lock":
+		return db.performLock(splits)
+	case "sem":
+		return db.performSemaphore(splits)
+	case "txn":
+		return db.performTxn(splits)
+	default:
+		return "", ErrInvalidDatabaseRequest
 	}
 }
 
-func (db *Database) performGet(splits []string) string {
+// performGet returns the revision at which the key was last updated followed by its value, e.g. "@5 value".
+// If a revision is given, the value of the key as of that revision is returned instead.
+func (db *Database) performGet(splits []string) (string, error) {
+	if len(splits) == 3 && strings.HasPrefix(splits[2], REVISION_PREFIX) {
+		return db.performGetAt(splits[1], splits[2])
+	}
 	if len(splits) != 2 {
-		return INVALID_DATABASE_REQUEST
+		return "", ErrInvalidDatabaseRequest
 	}
-	key := strings.TrimSpace(splits[1])
-	val, exists := db.store[key]
+	entry, exists := db.store.Get(splits[1])
 	if !exists {
-		return VALUE_DOES_NOT_EXIST
+		return "", ErrValueDoesNotExist
+	}
+	return formatRevision(entry.revision) + " " + entry.value, nil
+}
+
+// performSet sets the value of a key. Options for the ttl & the lease of the key replace the existing ones.
+func (db *Database) performSet(splits []string) (string, error) {
+	if len(splits) < 3 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	splits, ttl, lease, err := db.parseSetOptions(splits)
+	if err != nil {
+		return "", err
 	}
-	return val
+	db.put(splits[1], strings.Join(splits[2:], " "))
+	entry, _ := db.store.Get(splits[1])
+	entry.ttl, entry.lease = ttl, lease
+	db.store.Put(splits[1], entry)
+	return UPDATE_PERFORMED_SUCCESSFULLY + " " + formatRevision(db.revision), nil
 }
 
-func (db *Database) performSet(splits []string) string {
+func (db *Database) performDelete(splits []string) (string, error) {
+	if len(splits) != 2 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	if !db.remove(splits[1]) {
+		return "", ErrValueDoesNotExist
+	}
+	return UPDATE_PERFORMED_SUCCESSFULLY, nil
+}
+
+func (db *Database) performExists(splits []string) (string, error) {
+	if len(splits) != 2 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	_, exists := db.store.Get(splits[1])
+	return strconv.FormatBool(exists), nil
+}
+
+func (db *Database) performCas(splits []string) (string, error) {
+	if len(splits) != 4 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	key, expected, val := splits[1], splits[2], splits[3]
+	current, exists := db.store.Get(key)
+	if !exists {
+		return "", ErrValueDoesNotExist
+	}
+	if current.value != expected {
+		return "", ErrCompareFailed
+	}
+	db.put(key, val)
+	return UPDATE_PERFORMED_SUCCESSFULLY, nil
+}
+
+// performIncr adds delta to the integer value of a key. A key that does not exist is treated as 0.
+// The key is left untouched if the sum does not fit in an int64.
+func (db *Database) performIncr(splits []string) (string, error) {
 	if len(splits) != 3 {
-		return INVALID_DATABASE_REQUEST
+		return "", ErrInvalidDatabaseRequest
+	}
+	delta, err := strconv.ParseInt(splits[2], 10, 64)
+	if err != nil {
+		return "", ErrInvalidDatabaseRequest
+	}
+	current := int64(0)
+	if entry, exists := db.store.Get(splits[1]); exists {
+		current, err = strconv.ParseInt(entry.value, 10, 64)
+		if err != nil {
+			return "", ErrValueNotAnInteger
+		}
+	}
+	sum := current + delta
+	if (delta > 0 && sum < current) || (delta < 0 && sum > current) {
+		return "", ErrIntegerOverflow
+	}
+	val := strconv.FormatInt(sum, 10)
+	db.put(splits[1], val)
+	return val, nil
+}
+
+// performAppend appends a suffix to the value of a key. A key that does not exist is treated as empty.
+func (db *Database) performAppend(splits []string) (string, error) {
+	if len(splits) < 3 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	entry, _ := db.store.Get(splits[1])
+	val := entry.value + strings.Join(splits[2:], " ")
+	db.put(splits[1], val)
+	return val, nil
+}
+
+// performMget returns one line per key with the key & its value separated by a space.
+// Keys that do not exist are listed without a value.
+func (db *Database) performMget(splits []string) (string, error) {
+	if len(splits) < 2 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	lines := make([]string, 0, len(splits)-1)
+	for _, key := range splits[1:] {
+		if entry, exists := db.store.Get(key); exists {
+			lines = append(lines, key+" "+entry.value)
+		} else {
+			lines = append(lines, key)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (db *Database) performMset(splits []string) (string, error) {
+	if len(splits) < 3 || len(splits)%2 != 1 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	for i := 1; i < len(splits); i += 2 {
+		db.put(splits[i], splits[i+1])
+	}
+	return UPDATE_PERFORMED_SUCCESSFULLY, nil
+}
+
+// put sets the value of a key at the current revision & increments its version. The ttl & lease of the key are retained.
+func (db *Database) put(key string, value string) {
+	current, _ := db.store.Get(key)
+	db.store.Put(key, dbEntry{
+		value:    value,
+		version:  current.version + 1,
+		revision: db.revision,
+		ttl:      current.ttl,
+		lease:    current.lease,
+	})
+	db.recordHistory(key, dbRevision{revision: db.revision, value: value})
+}
+
+// remove deletes a key at the current revision. It returns false if the key does not exist.
+func (db *Database) remove(key string) bool {
+	if !db.store.Delete(key) {
+		return false
 	}
-	key, val := strings.TrimSpace(splits[1]), strings.TrimSpace(splits[2])
-	db.store[key] = val
-	return UPDATE_PERFORMED_SUCCESSFULLY
+	db.recordHistory(key, dbRevision{revision: db.revision, deleted: true})
+	return true
 }
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// applyNext applies an operation at the revision after the last applied one
+func applyNext(db *Database, operation string) (string, error) {
+	return db.Apply(operation, db.revision+1)
+}
+
+// mustApply applies an operation which is expected to succeed at the next revision & returns its value
+func mustApply(t *testing.T, db *Database, operation string) string {
+	t.Helper()
+	value, err := applyNext(db, operation)
+	if err != nil {
+		t.Fatalf("Apply(%q) = %v", operation, err)
+	}
+	return value
+}
+
+func TestSetGetDelete(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "set a hello world")
+	if value := mustApply(t, db, "get a"); value != "@1 hello world" {
+		t.Errorf("get a = %q, want @1 hello world", value)
+	}
+	if value := mustApply(t, db, "exists a"); value != "true" {
+		t.Errorf("exists a = %q, want true", value)
+	}
+
+	mustApply(t, db, "delete a")
+	if _, err := applyNext(db, "get a"); err != ErrValueDoesNotExist {
+		t.Errorf("get of a deleted key = %v, want %v", err, ErrValueDoesNotExist)
+	}
+	if _, err := applyNext(db, "delete a"); err != ErrValueDoesNotExist {
+		t.Errorf("delete of a deleted key = %v, want %v", err, ErrValueDoesNotExist)
+	}
+	if value := mustApply(t, db, "exists a"); value != "false" {
+		t.Errorf("exists a = %q, want false", value)
+	}
+}
+
+func TestCas(t *testing.T) {
+	db := NewDatabase()
+	if _, err := applyNext(db, "cas a 1 2"); err != ErrValueDoesNotExist {
+		t.Errorf("cas of a missing key = %v, want %v", err, ErrValueDoesNotExist)
+	}
+	mustApply(t, db, "set a 1")
+	if _, err := applyNext(db, "cas a 2 3"); err != ErrCompareFailed {
+		t.Errorf("cas with a stale value = %v, want %v", err, ErrCompareFailed)
+	}
+	mustApply(t, db, "cas a 1 3")
+	if value := mustApply(t, db, "get a"); value != "@4 3" {
+		t.Errorf("get a = %q, want @4 3", value)
+	}
+}
+
+func TestIncrAndAppend(t *testing.T) {
+	db := NewDatabase()
+	// keys which do not exist count from 0 or the empty string
+	if value := mustApply(t, db, "incr n 5"); value != "5" {
+		t.Errorf("incr n 5 = %q, want 5", value)
+	}
+	if value := mustApply(t, db, "incr n -7"); value != "-2" {
+		t.Errorf("incr n -7 = %q, want -2", value)
+	}
+	if value := mustApply(t, db, "append s x y"); value != "x y" {
+		t.Errorf("append s x y = %q, want x y", value)
+	}
+	if value := mustApply(t, db, "append s !"); value != "x y!" {
+		t.Errorf("append s ! = %q, want x y!", value)
+	}
+	if _, err := applyNext(db, "incr s 1"); err != ErrValueNotAnInteger {
+		t.Errorf("incr of a string = %v, want %v", err, ErrValueNotAnInteger)
+	}
+	if _, err := applyNext(db, "incr n one"); err != ErrInvalidDatabaseRequest {
+		t.Errorf("incr by a string = %v, want %v", err, ErrInvalidDatabaseRequest)
+	}
+}
+
+func TestIncrOverflow(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "set max 9223372036854775807")
+	mustApply(t, db, "set min -9223372036854775808")
+	for _, operation := range []string{"incr max 1", "incr min -1", "incr max 9223372036854775807"} {
+		if _, err := applyNext(db, operation); err != ErrIntegerOverflow {
+			t.Errorf("Apply(%q) = %v, want %v", operation, err, ErrIntegerOverflow)
+		}
+	}
+	if value := mustApply(t, db, "mget max min"); value != "max 9223372036854775807\nmin -9223372036854775808" {
+		t.Errorf("mget max min = %q after overflows", value)
+	}
+	if value := mustApply(t, db, "incr max -9223372036854775808"); value != "-1" {
+		t.Errorf("incr max by the minimum = %q, want -1", value)
+	}
+	if _, err := DecodeResult(EncodeResult("", ErrIntegerOverflow)); err != ErrIntegerOverflow {
+		t.Errorf("decoded %v, want %v", err, ErrIntegerOverflow)
+	}
+}
+
+func TestDeletedKeysDropTheirHistory(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "set a 1")
+	mustApply(t, db, "delete a")
+	mustApply(t, db, "set b 1")
+	mustApply(t, db, "delete b")
+	mustApply(t, db, "set b 2")
+	if value := mustApply(t, db, "get a @1"); value != "@1 1" {
+		t.Errorf("get a @1 = %q while the deletion is in the change log", value)
+	}
+	for i := 0; i < CHANGE_LOG_LENGTH; i++ {
+		mustApply(t, db, "set c "+strconv.Itoa(i))
+	}
+
+	if _, exists := db.history["a"]; exists {
+		t.Error("history of a is retained after its deletion left the change log")
+	}
+	if len(db.history["b"]) != 3 {
+		t.Errorf("history of b = %+v, want it retained after b was set again", db.history["b"])
+	}
+	if _, err := applyNext(db, "get a @1"); err != ErrRevisionCompacted {
+		t.Errorf("get a @1 = %v after its history was dropped, want %v", err, ErrRevisionCompacted)
+	}
+	if _, err := applyNext(db, "get a @"+strconv.Itoa(db.revision)); err != ErrValueDoesNotExist {
+		t.Errorf("get a at the last revision = %v, want %v", err, ErrValueDoesNotExist)
+	}
+}
+
+func TestMsetMget(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "mset a 1 b 2")
+	if value := mustApply(t, db, "mget b c a"); value != "b 2\nc\na 1" {
+		t.Errorf("mget b c a = %q", value)
+	}
+}
+
+func TestInvalidOperations(t *testing.T) {
+	db := NewDatabase()
+	for _, operation := range []string{
+		"",
+		"unknown a",
+		"get",
+		"get a b",
+		"set a",
+		"delete a b",
+		"exists",
+		"cas a 1",
+		"incr a",
+		"append a",
+		"mget",
+		"mset a 1 b",
+	} {
+		if _, err := applyNext(db, operation); err != ErrInvalidDatabaseRequest {
+			t.Errorf("Apply(%q) = %v, want %v", operation, err, ErrInvalidDatabaseRequest)
+		}
+	}
+}
+
+func TestDecodeResult(t *testing.T) {
+	if value, err := DecodeResult(EncodeResult("a b", nil)); value != "a b" || err != nil {
+		t.Errorf("decoded %q, %v, want a b", value, err)
+	}
+	if _, err := DecodeResult(EncodeResult("", ErrCompareFailed)); err != ErrCompareFailed {
+		t.Errorf("decoded %v, want %v", err, ErrCompareFailed)
+	}
+	// codes unknown to the client are kept, while any other result is malformed
+	if _, err := DecodeResult("error new_code"); err == nil || err.Error() != "new_code" {
+		t.Errorf("decoded %v, want new_code", err)
+	}
+	if _, err := DecodeResult("unexpected 1"); !errors.Is(err, ErrMalformedResponse) {
+		t.Errorf("decoded %v, want %v", err, ErrMalformedResponse)
+	}
+}
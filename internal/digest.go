@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// nextDigest extends the rolling digest of the committed operations with the log entry of the next committed
+// operation & its response. Two replicas which executed the same operations with the same responses have the same
+// digest at every commit number.
+func nextDigest(previous string, entry string, response string) string {
+	hash := sha256.New()
+	hash.Write([]byte(previous))
+	hash.Write([]byte{0})
+	hash.Write([]byte(entry))
+	hash.Write([]byte{0})
+	hash.Write([]byte(response))
+	return hex.EncodeToString(hash.Sum(nil))[:DIGEST_LENGTH]
+}
+
+// Digest returns the rolling digest of the operations up to a commit number, which is empty for commit number 0.
+// It returns false if the replica has not committed the operation yet.
+func (state *ServerState) Digest(commitNumber int) (string, bool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if commitNumber < 0 || commitNumber > len(state.digests) {
+		return "", false
+	}
+	if commitNumber == 0 {
+		return "", true
+	}
+	return state.digests[commitNumber-1], true
+}
+
+// ResetForStateTransfer drops the log & everything derived from executing it, so that the replica can rebuild its
+// state by executing the log of the leader from the start
+func (state *ServerState) ResetForStateTransfer() {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.log = make([]string, 0)
+	state.operationNumber = 0
+	state.commitNumber = 0
+	state.responses = make([]string, 0)
+	state.digests = make([]string, 0)
+	state.clientTable = make(map[int]ClientTableValue)
+	state.sessions = make(map[int]bool)
+	state.resetInvariants()
+}
+
+// Reset drops every key, lease & lock along with the retained history
+func (db *Database) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.store = newSkiplist()
+	db.history = make(map[string][]dbRevision)
+	db.changes = make([]ChangeEvent, 0)
+	db.leases = make(map[int]dbLease)
+	db.locks = make(map[string]*dbLock)
+	db.revision = 0
+}
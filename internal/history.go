@@ -0,0 +1,103 @@
+package internal
+
+import "strconv"
+
+// dbRevision is a single update of a key retained in the history of the Database
+type dbRevision struct {
+	revision int
+	value    string
+	deleted  bool
+}
+
+// ChangeEvent describes an update of a key. Type is either CHANGE_PUT or CHANGE_DELETE.
+type ChangeEvent struct {
+	Revision int
+	Type     string
+	Key      string
+	Value    string
+}
+
+// String prepares the string representation of a change, e.g. "@5 put key value" or "@6 delete key"
+func (event ChangeEvent) String() string {
+	result := formatRevision(event.Revision) + " " + event.Type + " " + event.Key
+	if event.Type == CHANGE_PUT {
+		result += " " + event.Value
+	}
+	return result
+}
+
+// Changes returns the changes of all keys at or after a revision in the order in which they were applied.
+// The last CHANGE_LOG_LENGTH changes are retained; it returns ErrRevisionCompacted if older changes are requested.
+func (db *Database) Changes(fromRevision int) ([]ChangeEvent, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.changes) == CHANGE_LOG_LENGTH && fromRevision < db.changes[0].Revision {
+		return nil, ErrRevisionCompacted
+	}
+	i := len(db.changes)
+	for i > 0 && db.changes[i-1].Revision >= fromRevision {
+		i -= 1
+	}
+	return append([]ChangeEvent{}, db.changes[i:]...), nil
+}
+
+// recordHistory appends an update to the history of a key & to the change log, discarding the oldest
+// updates beyond HISTORY_LENGTH & CHANGE_LOG_LENGTH respectively. The history of a key which is still deleted when
+// its deletion is discarded from the change log is dropped, so that deleted keys do not keep their history forever.
+func (db *Database) recordHistory(key string, update dbRevision) {
+	history := append(db.history[key], update)
+	if len(history) > HISTORY_LENGTH {
+		history = history[len(history)-HISTORY_LENGTH:]
+	}
+	db.history[key] = history
+
+	event := ChangeEvent{Revision: update.revision, Type: CHANGE_PUT, Key: key, Value: update.value}
+	if update.deleted {
+		event.Type = CHANGE_DELETE
+	}
+	db.changes = append(db.changes, event)
+	if len(db.changes) > CHANGE_LOG_LENGTH {
+		for _, discarded := range db.changes[:len(db.changes)-CHANGE_LOG_LENGTH] {
+			history := db.history[discarded.Key]
+			if discarded.Type == CHANGE_DELETE && len(history) > 0 && history[len(history)-1].deleted &&
+				history[len(history)-1].revision == discarded.Revision {
+				delete(db.history, discarded.Key)
+			}
+		}
+		db.changes = db.changes[len(db.changes)-CHANGE_LOG_LENGTH:]
+	}
+}
+
+// compacted reports whether the history of a key as of a revision may have been discarded, as the revision is
+// older than the change log
+func (db *Database) compacted(revision int) bool {
+	return len(db.changes) == CHANGE_LOG_LENGTH && revision < db.changes[0].Revision
+}
+
+// performGetAt returns the value of a key as of a revision, i.e. the value written by the latest update
+// at or before that revision, in the same format as performGet.
+func (db *Database) performGetAt(key string, revisionPart string) (string, error) {
+	revision, err := strconv.Atoi(revisionPart[len(REVISION_PREFIX):])
+	if err != nil || revision < 0 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	history := db.history[key]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].revision <= revision {
+			if history[i].deleted {
+				return "", ErrValueDoesNotExist
+			}
+			return formatRevision(history[i].revision) + " " + history[i].value, nil
+		}
+	}
+	// the key was either created after the revision or its updates till the revision were discarded
+	if len(history) == HISTORY_LENGTH || db.compacted(revision) {
+		return "", ErrRevisionCompacted
+	}
+	return "", ErrValueDoesNotExist
+}
+
+func formatRevision(revision int) string {
+	return REVISION_PREFIX + strconv.Itoa(revision)
+}
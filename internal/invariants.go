@@ -0,0 +1,14 @@
+//go:build !vsrdebug
+
+package internal
+
+// checkInvariants checks the safety invariants of Viewstamped Replication after a mutation of the state when built
+// with the vsrdebug tag. It is a no-op otherwise.
+func (state *ServerState) checkInvariants(mutation string) {}
+
+// resetInvariants lets the commit number of a replica start over once it starts with an empty state when built with
+// the vsrdebug tag. It is a no-op otherwise.
+func (state *ServerState) resetInvariants() {}
+
+// resetInvariantOracle forgets every replica when built with the vsrdebug tag. It is a no-op otherwise.
+func resetInvariantOracle() {}
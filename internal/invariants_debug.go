@@ -0,0 +1,101 @@
+//go:build vsrdebug
+
+package internal
+
+import (
+	"fmt"
+	"sync"
+)
+
+// invariantOracle is shared by every replica in the process, which is every replica of the cluster when it is run in
+// a simulation. It keeps the views which were started by their primary & the committed log as observed by all
+// replicas, against which every mutation of a ServerState is checked.
+type invariantOracle struct {
+	primaries map[int]int
+	committed []string
+	commits   map[int]int
+	mu        sync.Mutex
+}
+
+var oracle = newInvariantOracle()
+
+func newInvariantOracle() *invariantOracle {
+	return &invariantOracle{
+		primaries: make(map[int]int),
+		committed: make([]string, 0),
+		commits:   make(map[int]int),
+		mu:        sync.Mutex{},
+	}
+}
+
+// resetInvariantOracle forgets every replica, so that a new cluster can be run in the same process
+func resetInvariantOracle() {
+	oracle.mu.Lock()
+	defer oracle.mu.Unlock()
+
+	oracle.primaries = make(map[int]int)
+	oracle.committed = make([]string, 0)
+	oracle.commits = make(map[int]int)
+}
+
+// checkInvariants panics if a mutation of the state broke a safety invariant of Viewstamped Replication:
+// - the commit number does not exceed the operation number, which is the length of the log
+// - a view has at most one primary, which starts the view once through UpdateForNewView, while the other replicas
+// join it through UpdateView
+// - the commit number of a replica never decreases, except when the replica drops its state for a state transfer
+// - the log of every replica agrees with the committed log up to its commit number. Only the entries after the
+// previous commit number of the replica are compared unless the mutation replaced the log.
+// It must be invoked while holding state.mu.
+func (state *ServerState) checkInvariants(mutation string) {
+	oracle.mu.Lock()
+	defer oracle.mu.Unlock()
+
+	port := state.configuration[state.replicaNumber]
+	fail := func(format string, args ...any) {
+		panic(fmt.Sprintf("invariant violated by %s on replica %d in view %d: ", mutation, port, state.viewNumber) + fmt.Sprintf(format, args...))
+	}
+	if state.operationNumber != len(state.log) {
+		fail("operation number %d differs from log length %d", state.operationNumber, len(state.log))
+	}
+	if state.commitNumber > state.operationNumber {
+		fail("commit number %d exceeds operation number %d", state.commitNumber, state.operationNumber)
+	}
+	isPrimary := state.viewNumber%NUMBER_OF_NODES == state.replicaNumber
+	logReplaced := mutation == MUTATION_UPDATE_FOR_NEW_VIEW || mutation == MUTATION_UPDATE_VIEW
+	if mutation == MUTATION_UPDATE_FOR_NEW_VIEW {
+		if !isPrimary {
+			fail("replica is not the primary of the view")
+		}
+		if primary, exists := oracle.primaries[state.viewNumber]; exists {
+			fail("view was already started by replica %d", primary)
+		}
+		oracle.primaries[state.viewNumber] = port
+	} else if mutation == MUTATION_UPDATE_VIEW && isPrimary {
+		fail("primary joined its own view as a backup")
+	}
+	previousCommit := oracle.commits[port]
+	if state.commitNumber < previousCommit {
+		fail("commit number went back from %d to %d", previousCommit, state.commitNumber)
+	}
+	oracle.commits[port] = state.commitNumber
+
+	from := previousCommit
+	if logReplaced {
+		from = 0
+	}
+	for op := from; op < state.commitNumber; op++ {
+		if op == len(oracle.committed) {
+			oracle.committed = append(oracle.committed, state.log[op])
+		} else if oracle.committed[op] != state.log[op] {
+			fail("operation %d is %q, which was committed as %q", op+1, state.log[op], oracle.committed[op])
+		}
+	}
+}
+
+// resetInvariants lets the commit number of a replica start over once it starts with an empty state
+func (state *ServerState) resetInvariants() {
+	oracle.mu.Lock()
+	defer oracle.mu.Unlock()
+
+	delete(oracle.commits, state.configuration[state.replicaNumber])
+}
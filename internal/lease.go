@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dbLease is a lease that keys can be attached to & locks can be held by. Keys attached to a lease are deleted &
+// locks held by it are released when the lease is revoked or expires. revision is the revision at which the lease was granted or last kept alive
+// & owner is the session of the client which granted it, whose expiry revokes the lease.
+type dbLease struct {
+	ttl      time.Duration
+	revision int
+	owner    int
+}
+
+// parseSetOptions separates the trailing "ttl=<duration>" & "lease=<id>" options of a set operation from its value.
+// It returns the remaining tokens along with the ttl & lease, which are 0 if not given.
+func (db *Database) parseSetOptions(splits []string) ([]string, time.Duration, int, error) {
+	ttl, lease := time.Duration(0), 0
+	for len(splits) > 3 {
+		last := splits[len(splits)-1]
+		if value, found := strings.CutPrefix(last, TTL_OPTION); found {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				return nil, 0, 0, ErrInvalidDatabaseRequest
+			}
+			ttl = parsed
+		} else if value, found := strings.CutPrefix(last, LEASE_OPTION); found {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, 0, 0, ErrInvalidDatabaseRequest
+			}
+			if _, exists := db.leases[parsed]; !exists {
+				return nil, 0, 0, ErrLeaseNotFound
+			}
+			lease = parsed
+		} else {
+			break
+		}
+		splits = splits[:len(splits)-1]
+	}
+	return splits, ttl, lease, nil
+}
+
+// performLease handles the lease operations:
+// - lease grant ttl: creates a lease & returns its id, which is the revision of the operation
+// - lease keepalive id: restarts the ttl of a lease
+// - lease revoke id: deletes a lease along with the keys attached to it
+// - lease expire id: same as revoke. It is proposed by the leader once the ttl of a lease has passed
+func (db *Database) performLease(splits []string) (string, error) {
+	if len(splits) != 3 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	if splits[1] == "grant" {
+		ttl, err := time.ParseDuration(splits[2])
+		if err != nil || ttl <= 0 {
+			return "", ErrInvalidDatabaseRequest
+		}
+		db.leases[db.revision] = dbLease{ttl: ttl, revision: db.revision, owner: db.client}
+		return strconv.Itoa(db.revision), nil
+	}
+
+	id, err := strconv.Atoi(splits[2])
+	if err != nil {
+		return "", ErrInvalidDatabaseRequest
+	}
+	lease, exists := db.leases[id]
+	switch splits[1] {
+	case "keepalive":
+		if !exists {
+			return "", ErrLeaseNotFound
+		}
+		lease.revision = db.revision
+		db.leases[id] = lease
+		return lease.ttl.String(), nil
+	case "revoke", "expire":
+		if !exists {
+			return "", ErrLeaseNotFound
+		}
+		db.revokeLease(id)
+		return UPDATE_PERFORMED_SUCCESSFULLY, nil
+	}
+	return "", ErrInvalidDatabaseRequest
+}
+
+// RevokeSessionLeases revokes every lease owned by the expired sessions at a revision, deleting the keys attached
+// to them & releasing the locks & semaphore slots held by them. It is applied along with the expiry of the sessions.
+func (db *Database) RevokeSessionLeases(sessionIds []int, revision int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.revision, db.client = revision, 0
+	owned := make([]int, 0)
+	for id, lease := range db.leases {
+		if slices.Contains(sessionIds, lease.owner) {
+			owned = append(owned, id)
+		}
+	}
+	// leases are revoked in the order they were granted, so that every replica records the same changes
+	sort.Ints(owned)
+	for _, id := range owned {
+		db.revokeLease(id)
+	}
+}
+
+// revokeLease deletes a lease, the keys attached to it & releases the locks held by it
+func (db *Database) revokeLease(id int) {
+	delete(db.leases, id)
+	db.releaseLease(id)
+	attached := make([]string, 0)
+	db.store.Ascend("", func(key string, entry dbEntry) bool {
+		if entry.lease == id {
+			attached = append(attached, key)
+		}
+		return true
+	})
+	for _, key := range attached {
+		db.remove(key)
+	}
+}
+
+// LeaseKeys returns the keys attached to a lease along with the names of the locks & semaphores held by it
+func (db *Database) LeaseKeys(id int) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	keys := make([]string, 0)
+	db.store.Ascend("", func(key string, entry dbEntry) bool {
+		if entry.lease == id {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, name := range db.lockNames() {
+		if _, holds := db.locks[name].holders[id]; holds {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// performExpire handles "expire key @revision [key @revision ...]" proposed by the leader once the ttl of keys has
+// passed. A key is only deleted if it was not updated after the given revision, as an update restarts its ttl.
+func (db *Database) performExpire(splits []string) (string, error) {
+	if len(splits) < 3 || len(splits)%2 != 1 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	expired := 0
+	for i := 1; i < len(splits); i += 2 {
+		if !strings.HasPrefix(splits[i+1], REVISION_PREFIX) {
+			return "", ErrInvalidDatabaseRequest
+		}
+		revision, err := strconv.Atoi(splits[i+1][len(REVISION_PREFIX):])
+		if err != nil {
+			return "", ErrInvalidDatabaseRequest
+		}
+		entry, exists := db.store.Get(splits[i])
+		if exists && entry.ttl > 0 && entry.revision == revision {
+			db.remove(splits[i])
+			expired += 1
+		}
+	}
+	return strconv.Itoa(expired), nil
+}
+
+// Expirable is a key with a ttl, a lease or a client session, along with the revision from which its ttl runs
+type Expirable struct {
+	Key      string
+	LeaseId  int
+	ClientId int
+	Revision int
+	TTL      time.Duration
+}
+
+// Expirables returns every key with a ttl & every lease
+func (db *Database) Expirables() []Expirable {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	expirables := make([]Expirable, 0)
+	db.store.Ascend("", func(key string, entry dbEntry) bool {
+		if entry.ttl > 0 {
+			expirables = append(expirables, Expirable{Key: key, Revision: entry.revision, TTL: entry.ttl})
+		}
+		return true
+	})
+	for id, lease := range db.leases {
+		expirables = append(expirables, Expirable{LeaseId: id, Revision: lease.revision, TTL: lease.ttl})
+	}
+	return expirables
+}
+
+// ExpiryTracker is used by the leader to decide when keys, leases & client sessions expire. Replicas do not share a clock, so the
+// ttl of a key or lease runs from the time the leader first observes it at its current revision. A new leader
+// therefore restarts every ttl, which can only extend the lifetime of a key, never shorten it.
+type ExpiryTracker struct {
+	observed map[Expirable]time.Time
+	mu       sync.Mutex
+}
+
+// NewExpiryTracker creates a new instance of ExpiryTracker
+func NewExpiryTracker() *ExpiryTracker {
+	return &ExpiryTracker{
+		observed: make(map[Expirable]time.Time),
+		mu:       sync.Mutex{},
+	}
+}
+
+// Expired records the current expirables & returns the ones whose ttl has passed.
+// Expirables which no longer exist are forgotten.
+func (tracker *ExpiryTracker) Expired(expirables []Expirable, now time.Time) []Expirable {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	current := make(map[Expirable]time.Time)
+	expired := make([]Expirable, 0)
+	for _, expirable := range expirables {
+		observed, exists := tracker.observed[expirable]
+		if !exists {
+			observed = now
+		}
+		current[expirable] = observed
+		if now.Sub(observed) >= expirable.TTL {
+			expired = append(expired, expirable)
+		}
+	}
+	tracker.observed = current
+	return expired
+}
+
+// Reset forgets every observed expirable, restarting all the ttls. It is invoked when a replica becomes leader.
+func (tracker *ExpiryTracker) Reset() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.observed = make(map[Expirable]time.Time)
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExpireSkipsUpdatedKeys(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "set a 1 ttl=1s")
+	mustApply(t, db, "set b 2 ttl=1s")
+	mustApply(t, db, "set c 3")
+	// b is updated after the leader observed it at revision 2, which restarts its ttl
+	mustApply(t, db, "set b 4 ttl=1s")
+
+	if expired := mustApply(t, db, "expire a @1 b @2 c @3"); expired != "1" {
+		t.Errorf("expired %s keys, want only a", expired)
+	}
+	if value := mustApply(t, db, "mget a b c"); value != "a\nb 4\nc 3" {
+		t.Errorf("mget a b c = %q after expiry", value)
+	}
+	for _, operation := range []string{"expire", "expire b", "expire b 4", "expire b @x", "set d 1 ttl=0s", "set d 1 ttl=x"} {
+		if _, err := applyNext(db, operation); err != ErrInvalidDatabaseRequest {
+			t.Errorf("Apply(%q) = %v, want %v", operation, err, ErrInvalidDatabaseRequest)
+		}
+	}
+}
+
+func TestLeaseRevokeDeletesAttachedKeys(t *testing.T) {
+	db := NewDatabase()
+	lease := mustApply(t, db, "lease grant 30s")
+	mustApply(t, db, "set a 1 lease="+lease)
+	mustApply(t, db, "set b 2")
+	if _, err := applyNext(db, "set c 3 lease=99"); err != ErrLeaseNotFound {
+		t.Errorf("set with an unknown lease = %v, want %v", err, ErrLeaseNotFound)
+	}
+	if ttl := mustApply(t, db, "lease keepalive "+lease); ttl != "30s" {
+		t.Errorf("keepalive = %q, want the ttl of the lease", ttl)
+	}
+
+	mustApply(t, db, "lease revoke "+lease)
+	if value := mustApply(t, db, "mget a b"); value != "a\nb 2" {
+		t.Errorf("mget a b = %q after the lease was revoked", value)
+	}
+	for _, operation := range []string{"lease revoke " + lease, "lease keepalive " + lease, "lease expire " + lease} {
+		if _, err := applyNext(db, operation); err != ErrLeaseNotFound {
+			t.Errorf("Apply(%q) = %v, want %v", operation, err, ErrLeaseNotFound)
+		}
+	}
+}
+
+func TestExpiryTracker(t *testing.T) {
+	tracker := NewExpiryTracker()
+	start := time.Now()
+	key := Expirable{Key: "a", Revision: 1, TTL: time.Second}
+	lease := Expirable{LeaseId: 2, Revision: 2, TTL: 3 * time.Second}
+
+	if expired := tracker.Expired([]Expirable{key, lease}, start); len(expired) != 0 {
+		t.Errorf("expired %v on first observation", expired)
+	}
+	if expired := tracker.Expired([]Expirable{key, lease}, start.Add(time.Second)); !slices.Equal(expired, []Expirable{key}) {
+		t.Errorf("expired %v once the ttl of a passed", expired)
+	}
+	// an update of the key at a later revision restarts its ttl
+	updated := Expirable{Key: "a", Revision: 3, TTL: time.Second}
+	if expired := tracker.Expired([]Expirable{updated, lease}, start.Add(1500*time.Millisecond)); len(expired) != 0 {
+		t.Errorf("expired %v after the key was updated", expired)
+	}
+	// a new leader restarts every ttl
+	tracker.Reset()
+	if expired := tracker.Expired([]Expirable{updated, lease}, start.Add(4*time.Second)); len(expired) != 0 {
+		t.Errorf("expired %v after a reset", expired)
+	}
+}
+
+func TestLeaseKeys(t *testing.T) {
+	db := NewDatabase()
+	for revision, operation := range []string{
+		"lease grant 30s",
+		"lease grant 30s",
+		"set a 1 lease=1",
+		"set b 2 lease=2",
+		"set c 3",
+		"lock acquire l 1",
+		"sem acquire s 2 2",
+	} {
+		db.Apply(operation, revision+1)
+	}
+	if keys := db.LeaseKeys(1); !slices.Equal(keys, []string{"a", "l"}) {
+		t.Errorf("LeaseKeys(1) = %q, want [a l]", keys)
+	}
+	if keys := db.LeaseKeys(2); !slices.Equal(keys, []string{"b", "s"}) {
+		t.Errorf("LeaseKeys(2) = %q, want [b s]", keys)
+	}
+	if keys := db.LeaseKeys(3); len(keys) != 0 {
+		t.Errorf("LeaseKeys(3) = %q, want none", keys)
+	}
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dbLock is a semaphore which can be held by up to limit leases at a time. A lock is a semaphore with a limit of 1.
+// holders maps the lease of each holder to its fencing token, which is the revision at which it was acquired.
+// Tokens of a lock only ever increase, so a downstream service can reject writes carrying an older token.
+type dbLock struct {
+	limit   int
+	holders map[int]int
+}
+
+// performLock handles the lock operations, where lease is the id of the lease held by the client:
+// - lock acquire name lease: acquires a lock & returns the fencing token
+// - lock release name lease: releases a lock
+// - lock holders name: lists the lease & fencing token of every holder, one per line
+func (db *Database) performLock(splits []string) (string, error) {
+	if len(splits) == 3 && splits[1] == "holders" {
+		return db.lockHolders(splits[2]), nil
+	}
+	if len(splits) != 4 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	lease, err := strconv.Atoi(splits[3])
+	if err != nil {
+		return "", ErrInvalidDatabaseRequest
+	}
+	switch splits[1] {
+	case "acquire":
+		return db.acquire(splits[2], 1, lease)
+	case "release":
+		return db.release(splits[2], lease)
+	}
+	return "", ErrInvalidDatabaseRequest
+}
+
+// performSemaphore handles the semaphore operations, where lease is the id of the lease held by the client:
+// - sem acquire name limit lease: acquires one of limit slots of a semaphore & returns the fencing token
+// - sem release name lease: releases the slot held by the lease
+func (db *Database) performSemaphore(splits []string) (string, error) {
+	if len(splits) == 5 && splits[1] == "acquire" {
+		limit, err := strconv.Atoi(splits[3])
+		if err != nil || limit <= 0 {
+			return "", ErrInvalidDatabaseRequest
+		}
+		lease, err := strconv.Atoi(splits[4])
+		if err != nil {
+			return "", ErrInvalidDatabaseRequest
+		}
+		return db.acquire(splits[2], limit, lease)
+	}
+	if len(splits) == 4 && splits[1] == "release" {
+		lease, err := strconv.Atoi(splits[3])
+		if err != nil {
+			return "", ErrInvalidDatabaseRequest
+		}
+		return db.release(splits[2], lease)
+	}
+	return "", ErrInvalidDatabaseRequest
+}
+
+// acquire gives a slot of a lock to a lease. Acquiring a lock which is already held by the lease returns its
+// existing fencing token. A lock keeps the limit it was created with until it has no holders.
+func (db *Database) acquire(name string, limit int, lease int) (string, error) {
+	if _, exists := db.leases[lease]; !exists {
+		return "", ErrLeaseNotFound
+	}
+	lock, exists := db.locks[name]
+	if !exists {
+		lock = &dbLock{limit: limit, holders: make(map[int]int)}
+		db.locks[name] = lock
+	}
+	if token, holds := lock.holders[lease]; holds {
+		return strconv.Itoa(token), nil
+	}
+	if len(lock.holders) >= lock.limit {
+		return "", ErrLockHeld
+	}
+	lock.holders[lease] = db.revision
+	return strconv.Itoa(db.revision), nil
+}
+
+func (db *Database) release(name string, lease int) (string, error) {
+	lock, exists := db.locks[name]
+	if !exists {
+		return "", ErrLockNotHeld
+	}
+	if _, holds := lock.holders[lease]; !holds {
+		return "", ErrLockNotHeld
+	}
+	delete(lock.holders, lease)
+	if len(lock.holders) == 0 {
+		delete(db.locks, name)
+	}
+	return UPDATE_PERFORMED_SUCCESSFULLY, nil
+}
+
+// releaseLease releases every lock held by a lease. It is invoked when the lease is revoked or expires.
+func (db *Database) releaseLease(lease int) {
+	for _, name := range db.lockNames() {
+		if _, holds := db.locks[name].holders[lease]; holds {
+			db.release(name, lease)
+		}
+	}
+}
+
+func (db *Database) lockHolders(name string) string {
+	lock, exists := db.locks[name]
+	if !exists {
+		return ""
+	}
+	leases := make([]int, 0, len(lock.holders))
+	for lease := range lock.holders {
+		leases = append(leases, lease)
+	}
+	sort.Ints(leases)
+	lines := make([]string, 0, len(leases))
+	for _, lease := range leases {
+		lines = append(lines, strconv.Itoa(lease)+" "+strconv.Itoa(lock.holders[lease]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lockNames returns the names of all the locks in ascending order
+func (db *Database) lockNames() []string {
+	names := make([]string, 0, len(db.locks))
+	for name := range db.locks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// NewLogger creates a structured logger writing to w.
+// format is either "text" or "json" & level is one of "debug", "info", "warn" or "error".
+// It returns an error if either of them is not recognised.
+func NewLogger(w io.Writer, format string, level string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+
+	switch strings.ToLower(format) {
+	case LOG_FORMAT_TEXT:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case LOG_FORMAT_JSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
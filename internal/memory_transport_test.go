@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// memoryNetwork connects memoryTransports in the same process. Like datagrams, messages to a port without a
+// transport or with a full inbox are dropped.
+type memoryNetwork struct {
+	inboxes map[int]chan UdpMessage
+	mu      sync.Mutex
+}
+
+func newMemoryNetwork() *memoryNetwork {
+	return &memoryNetwork{
+		inboxes: make(map[int]chan UdpMessage),
+		mu:      sync.Mutex{},
+	}
+}
+
+// transport creates the transport of the node on a port
+func (network *memoryNetwork) transport(port int) *memoryTransport {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+
+	inbox := make(chan UdpMessage, 4096)
+	network.inboxes[port] = inbox
+	return &memoryTransport{
+		network: network,
+		port:    port,
+		inbox:   inbox,
+		done:    make(chan struct{}),
+	}
+}
+
+func (network *memoryNetwork) deliver(message UdpMessage, port int) {
+	network.mu.Lock()
+	inbox, exists := network.inboxes[port]
+	network.mu.Unlock()
+
+	if !exists {
+		return
+	}
+	select {
+	case inbox <- message:
+	default:
+	}
+}
+
+// memoryTransport is the Transport of a node on a memoryNetwork
+type memoryTransport struct {
+	network   *memoryNetwork
+	port      int
+	inbox     chan UdpMessage
+	auth      *Authenticator
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *memoryTransport) Send(message string, port int) error {
+	t.network.deliver(UdpMessage{Message: t.auth.Seal(message), FromPort: t.port}, port)
+	return nil
+}
+
+func (t *memoryTransport) Receive() (UdpMessage, error) {
+	select {
+	case message := <-t.inbox:
+		return message, nil
+	case <-t.done:
+		return UdpMessage{}, net.ErrClosed
+	}
+}
+
+func (t *memoryTransport) RecieveWithTimeout(timeout time.Duration) (UdpMessage, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case message := <-t.inbox:
+		return message, nil
+	case <-timer.C:
+		return UdpMessage{}, os.ErrDeadlineExceeded
+	case <-t.done:
+		return UdpMessage{}, net.ErrClosed
+	}
+}
+
+func (t *memoryTransport) SetAuthenticator(auth *Authenticator) {
+	t.auth = auth
+}
+
+func (t *memoryTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+	return nil
+}
+
+// pending returns the messages waiting in the inbox without blocking
+func (t *memoryTransport) pending() []UdpMessage {
+	messages := make([]UdpMessage, 0)
+	for {
+		select {
+		case message := <-t.inbox:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+var _ Transport = (*memoryTransport)(nil)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
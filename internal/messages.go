@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MessageError is the reason a message was rejected by its parser. Field is the name of the offending field, if any.
+type MessageError struct {
+	Type   string
+	Field  string
+	Reason string
+}
+
+func (e *MessageError) Error() string {
+	if e.Field == "" {
+		return "malformed " + e.Type + " message: " + e.Reason
+	}
+	return "malformed " + e.Type + " message: " + e.Reason + " " + e.Field
+}
+
+type clientRequestMessage struct {
+	sessionId     int
+	token         string
+	command       string
+	requestNumber int
+}
+
+type prepareRequestMessage struct {
+	viewNumber      int
+	command         string
+	requestNumber   int
+	clientId        int
+	operationNumber int
+	commitNumber    int
+}
+
+type prepareResponseMessage struct {
+	viewNumber      int
+	operationNumber int
+	clientId        int
+	replicaPort     int
+}
+
+type commitMessage struct {
+	viewNumber    int
+	commitNumber  int
+	requestNumber int
+	clientId      int
+	digest        string
+}
+
+type catchupRequestMessage struct {
+	replicaOperationNumber int
+	laggingOperationNumber int
+}
+
+type catchupResponseMessage struct {
+	commitNumber int
+	logs         []string
+}
+
+type startViewChangeMessage struct {
+	viewNumber int
+}
+
+type startViewMessage struct {
+	operationNumber int
+	viewNumber      int
+	commitNumber    int
+	logs            []string
+}
+
+// decodeMessage parses a message between nodes into the struct of its type, e.g. a *prepareRequestMessage for a
+// prepare request & a *doViewChange for a do view change request. Every number must be a non-negative integer, every
+// log entry must carry its request number & client & the log of a view must be as long as its operation number,
+// which is at least its commit number. It returns a *MessageError for any message which does not meet these.
+func decodeMessage(message string) (any, error) {
+	parts := strings.Split(message, DELIMETER)
+	msgType := parts[0]
+	if msgType == CLIENT_REQUEST_PREFIX {
+		return parseClientRequest(parts)
+	} else if msgType == PREPARE_REQUEST_PREFIX {
+		return parsePrepareRequest(parts)
+	} else if msgType == PREPARE_RESPONSE_PREFIX {
+		return parsePrepareResponse(parts)
+	} else if msgType == COMMIT_MESSAGE_PREFIX {
+		return parseCommitMessage(parts)
+	} else if msgType == CATCHUP_REQUEST_PREFIX {
+		return parseCatchupRequest(parts)
+	} else if msgType == CATCHUP_RESPONSE_PREFIX {
+		return parseCatchupResponse(parts)
+	} else if msgType == START_VIEW_CHANGE_PREFIX {
+		return parseStartViewChange(parts)
+	} else if msgType == DO_VIEW_CHANGE_PREFIX {
+		return parseDoViewChange(parts)
+	} else if msgType == START_VIEW_PREFIX {
+		return parseStartView(parts)
+	}
+	return nil, &MessageError{Type: msgType, Reason: PARSE_UNKNOWN_TYPE}
+}
+
+// parseClientRequest parses "client_request:<session id>:<token>:<command>:<request number>"
+func parseClientRequest(parts []string) (*clientRequestMessage, error) {
+	p := newMessageParser(parts, 5)
+	m := &clientRequestMessage{
+		sessionId:     p.number(1, "session"),
+		token:         p.text(2),
+		command:       p.text(3),
+		requestNumber: p.number(4, "request_number"),
+	}
+	return m, p.err
+}
+
+// parsePrepareRequest parses "prepare_request:<view>:<command>:<request number>:<client>:<operation number>:<commit number>"
+func parsePrepareRequest(parts []string) (*prepareRequestMessage, error) {
+	p := newMessageParser(parts, 7)
+	m := &prepareRequestMessage{
+		viewNumber:      p.number(1, "view_number"),
+		command:         p.text(2),
+		requestNumber:   p.number(3, "request_number"),
+		clientId:        p.number(4, "client_id"),
+		operationNumber: p.number(5, "operation_number"),
+		commitNumber:    p.number(6, "commit_number"),
+	}
+	return m, p.err
+}
+
+// parsePrepareResponse parses "prepare_response:<view>:<operation number>:<client>:<replica port>"
+func parsePrepareResponse(parts []string) (*prepareResponseMessage, error) {
+	p := newMessageParser(parts, 5)
+	m := &prepareResponseMessage{
+		viewNumber:      p.number(1, "view_number"),
+		operationNumber: p.number(2, "operation_number"),
+		clientId:        p.number(3, "client_id"),
+		replicaPort:     p.number(4, "replica_port"),
+	}
+	return m, p.err
+}
+
+// parseCommitMessage parses "commit_message:<view>:<commit number>:<request number>:<client>:<digest>"
+func parseCommitMessage(parts []string) (*commitMessage, error) {
+	p := newMessageParser(parts, 6)
+	m := &commitMessage{
+		viewNumber:    p.number(1, "view_number"),
+		commitNumber:  p.number(2, "commit_number"),
+		requestNumber: p.number(3, "request_number"),
+		clientId:      p.number(4, "client_id"),
+		digest:        p.text(5),
+	}
+	return m, p.err
+}
+
+// parseCatchupRequest parses "catchup_request:<operation number of the replica>:<operation number of the lagging replica>".
+// Operation numbers start at 1, so the lagging replica must be at an operation number of at least 1.
+func parseCatchupRequest(parts []string) (*catchupRequestMessage, error) {
+	p := newMessageParser(parts, 3)
+	m := &catchupRequestMessage{
+		replicaOperationNumber: p.number(1, "replica_operation_number"),
+		laggingOperationNumber: p.operationNumber(2, "lagging_operation_number"),
+	}
+	return m, p.err
+}
+
+// parseCatchupResponse parses "catchup_response:<commit number>:<log entries>"
+func parseCatchupResponse(parts []string) (*catchupResponseMessage, error) {
+	p := newMessageParser(parts, 3)
+	m := &catchupResponseMessage{
+		commitNumber: p.number(1, "commit_number"),
+		logs:         p.logs(2),
+	}
+	return m, p.err
+}
+
+// parseStartViewChange parses "start_view_change:<view>"
+func parseStartViewChange(parts []string) (*startViewChangeMessage, error) {
+	p := newMessageParser(parts, 2)
+	m := &startViewChangeMessage{
+		viewNumber: p.number(1, "view_number"),
+	}
+	return m, p.err
+}
+
+// parseDoViewChange parses "do_view_change:<old view>:<new view>:<operation number>:<commit number>:<log entries>"
+func parseDoViewChange(parts []string) (*doViewChange, error) {
+	p := newMessageParser(parts, 6)
+	m := &doViewChange{
+		oldViewNumber:   p.number(1, "old_view_number"),
+		newViewNumber:   p.number(2, "new_view_number"),
+		operationNumber: p.number(3, "operation_number"),
+		commitNumber:    p.number(4, "commit_number"),
+		logs:            p.logs(5),
+	}
+	p.view(m.operationNumber, m.commitNumber, m.logs)
+	return m, p.err
+}
+
+// parseStartView parses "start_view:<operation number>:<view>:<commit number>:<log entries>"
+func parseStartView(parts []string) (*startViewMessage, error) {
+	p := newMessageParser(parts, 5)
+	m := &startViewMessage{
+		operationNumber: p.number(1, "operation_number"),
+		viewNumber:      p.number(2, "view_number"),
+		commitNumber:    p.number(3, "commit_number"),
+		logs:            p.logs(4),
+	}
+	p.view(m.operationNumber, m.commitNumber, m.logs)
+	return m, p.err
+}
+
+// messageParser reads the fields of a message & keeps the first error it runs into, after which it returns zero values
+type messageParser struct {
+	parts []string
+	err   error
+}
+
+// newMessageParser creates a messageParser over the parts of a message, which must have exactly count parts
+func newMessageParser(parts []string, count int) *messageParser {
+	p := &messageParser{parts: parts}
+	if len(parts) != count {
+		p.err = &MessageError{Type: parts[0], Reason: PARSE_FIELD_COUNT}
+	}
+	return p
+}
+
+func (p *messageParser) text(index int) string {
+	if p.err != nil {
+		return ""
+	}
+	return p.parts[index]
+}
+
+func (p *messageParser) number(index int, field string) int {
+	if p.err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(p.parts[index])
+	if err != nil || n < 0 {
+		p.err = &MessageError{Type: p.parts[0], Field: field, Reason: PARSE_INVALID_NUMBER}
+		return 0
+	}
+	return n
+}
+
+// operationNumber reads a number which is at least 1
+func (p *messageParser) operationNumber(index int, field string) int {
+	n := p.number(index, field)
+	if p.err == nil && n < 1 {
+		p.err = &MessageError{Type: p.parts[0], Field: field, Reason: PARSE_INVALID_NUMBER}
+	}
+	return n
+}
+
+// logs splits "command-reqNo-clientId" entries joined by joinLogs. An empty field has no entries.
+func (p *messageParser) logs(index int) []string {
+	if p.err != nil || p.parts[index] == "" {
+		return make([]string, 0)
+	}
+	logs := strings.Split(p.parts[index], LOGS_DELIMETER)
+	for i, log := range logs {
+		rest, clientId, found := cutLast(log, LOG_DELIMETER)
+		_, requestNumber, foundRequestNumber := cutLast(rest, LOG_DELIMETER)
+		if !found || !foundRequestNumber || !isNonNegativeNumber(requestNumber) || !isNonNegativeNumber(clientId) {
+			p.err = &MessageError{Type: p.parts[0], Field: "logs", Reason: PARSE_INVALID_LOG}
+			return make([]string, 0)
+		}
+		logs[i] = logUnescaper.Replace(log)
+	}
+	return logs
+}
+
+// view checks that the log of a view has an entry for every operation number & that the commit number does not exceed it
+func (p *messageParser) view(operationNumber int, commitNumber int, logs []string) {
+	if p.err != nil {
+		return
+	}
+	if commitNumber > operationNumber || len(logs) != operationNumber {
+		p.err = &MessageError{Type: p.parts[0], Reason: PARSE_INCONSISTENT}
+	}
+}
+
+func isNonNegativeNumber(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 0
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// builtMessages returns a message of every type as built by a replica with a few operations in its log. The replica
+// is not part of any simulated cluster, so the oracle of invariants is reset first.
+func builtMessages() []string {
+	resetInvariantOracle()
+	state := NewServerState(STARTING_PORT)
+	state.RecordRequest("set a 1", 0, 7000, 7000)
+	state.RecordRequest("incr a 2", 1, 7000, 7000)
+	state.RecordCommit(7000, 0, EncodeResult(UPDATE_PERFORMED_SUCCESSFULLY, nil))
+	clientState := NewClientState(7000)
+	clientState.SetToken("secret")
+
+	return []string{
+		clientState.BuildClientRequest("get a"),
+		clientState.BuildRegisterRequest(),
+		state.BuildPrepareRequest("incr a 2", 1, 7000),
+		state.BuildPrepareResponse(2, 7000),
+		state.BuildCommitMessage(),
+		state.BuildCatchupRequest(3),
+		state.BuildCatchupResponse(0, 3),
+		state.BuildStartViewChangeRequest(),
+		state.BuildDoViewChangeRequest(1),
+		state.BuildStartViewRequest(),
+	}
+}
+
+func TestDecodeBuiltMessages(t *testing.T) {
+	for _, message := range builtMessages() {
+		if _, err := decodeMessage(message); err != nil {
+			t.Errorf("decodeMessage(%q) = %v", message, err)
+		}
+	}
+}
+
+func TestDecodeStartView(t *testing.T) {
+	decoded, err := decodeMessage("start_view:2:1:1:set a 1-0-7000,incr a-b 2-1-7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := decoded.(*startViewMessage)
+	if m.operationNumber != 2 || m.viewNumber != 1 || m.commitNumber != 1 || len(m.logs) != 2 || m.logs[1] != "incr a-b 2-1-7000" {
+		t.Errorf("unexpected start view %+v", m)
+	}
+}
+
+func TestDecodeEscapedLogs(t *testing.T) {
+	state := NewServerState(STARTING_PORT)
+	state.RecordRequest("set a x,y", 0, 7000, 7000)
+	state.RecordRequest("set b 100%2C%", 1, 7000, 7000)
+	decoded, err := decodeMessage(state.BuildStartViewRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs := decoded.(*startViewMessage).logs; strings.Join(logs, "\n") != strings.Join(state.log, "\n") {
+		t.Errorf("decoded logs %q, want %q", logs, state.log)
+	}
+}
+
+func TestBuildCatchupResponseClampsOperationNumbers(t *testing.T) {
+	state := NewServerState(STARTING_PORT)
+	state.RecordRequest("set a 1", 0, 7000, 7000)
+	for _, numbers := range [][2]int{{0, 0}, {5, 1}, {0, 9}} {
+		decoded, err := decodeMessage(state.BuildCatchupResponse(numbers[0], numbers[1]))
+		if err != nil {
+			t.Fatalf("BuildCatchupResponse(%d, %d): %v", numbers[0], numbers[1], err)
+		}
+		if logs := decoded.(*catchupResponseMessage).logs; len(logs) > len(state.log) {
+			t.Errorf("BuildCatchupResponse(%d, %d) sent %d entries of a log of %d", numbers[0], numbers[1], len(logs), len(state.log))
+		}
+	}
+}
+
+func TestDecodeMalformedMessages(t *testing.T) {
+	tests := []struct {
+		message string
+		reason  string
+		field   string
+	}{
+		{"", PARSE_UNKNOWN_TYPE, ""},
+		{"hello:1", PARSE_UNKNOWN_TYPE, ""},
+		{"client_request:0:register:5", PARSE_FIELD_COUNT, ""},
+		{"client_request:x::get a:5", PARSE_INVALID_NUMBER, "session"},
+		{"client_request:0::get a:x", PARSE_INVALID_NUMBER, "request_number"},
+		{"prepare_request:0:set a 1:0:7000:1", PARSE_FIELD_COUNT, ""},
+		{"prepare_request:-1:set a 1:0:7000:1:0", PARSE_INVALID_NUMBER, "view_number"},
+		{"prepare_response:0:1:7000", PARSE_FIELD_COUNT, ""},
+		{"commit_message:0:1:0:7000:abc:extra", PARSE_FIELD_COUNT, ""},
+		{"commit_message:0:one:0:7000:abc", PARSE_INVALID_NUMBER, "commit_number"},
+		{"catchup_request:1", PARSE_FIELD_COUNT, ""},
+		{"catchup_request:0:0", PARSE_INVALID_NUMBER, "lagging_operation_number"},
+		{"catchup_response:1:set a 1", PARSE_INVALID_LOG, "logs"},
+		{"catchup_response:1:set a 1-0-x", PARSE_INVALID_LOG, "logs"},
+		{"start_view_change:", PARSE_INVALID_NUMBER, "view_number"},
+		{"do_view_change:0:1:2:1:set a 1-0-7000", PARSE_INCONSISTENT, ""},
+		{"start_view:1:1:2:set a 1-0-7000", PARSE_INCONSISTENT, ""},
+	}
+	for _, test := range tests {
+		_, err := decodeMessage(test.message)
+		var messageErr *MessageError
+		if !errors.As(err, &messageErr) {
+			t.Errorf("decodeMessage(%q) = %v, want a *MessageError", test.message, err)
+			continue
+		}
+		if messageErr.Reason != test.reason || messageErr.Field != test.field {
+			t.Errorf("decodeMessage(%q) = %q %q, want %q %q", test.message, messageErr.Reason, messageErr.Field, test.reason, test.field)
+		}
+	}
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	for _, message := range builtMessages() {
+		f.Add(message)
+	}
+	f.Fuzz(func(t *testing.T, message string) {
+		decoded, err := decodeMessage(message)
+		if err != nil {
+			var messageErr *MessageError
+			if !errors.As(err, &messageErr) {
+				t.Fatalf("decodeMessage(%q) returned %T", message, err)
+			}
+			return
+		}
+		if !strings.HasPrefix(message, strings.Split(message, DELIMETER)[0]+DELIMETER) {
+			t.Fatalf("decoded %q without any fields", message)
+		}
+		switch m := decoded.(type) {
+		case *startViewMessage:
+			if m.commitNumber > m.operationNumber || len(m.logs) != m.operationNumber {
+				t.Fatalf("decoded inconsistent view %+v", m)
+			}
+		case *doViewChange:
+			if m.commitNumber > m.operationNumber || len(m.logs) != m.operationNumber {
+				t.Fatalf("decoded inconsistent view %+v", m)
+			}
+		case *catchupResponseMessage:
+			for _, log := range m.logs {
+				if _, _, clientId := parseLogEntry(log); clientId < 0 {
+					t.Fatalf("decoded log entry %q with a negative client", log)
+				}
+			}
+		}
+	})
+}
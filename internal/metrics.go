@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// default latency buckets in seconds, same as the defaults of the Prometheus client libraries
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Counter is a monotonically increasing value, optionally partitioned by a single label
+type Counter struct {
+	name   string
+	help   string
+	label  string
+	values map[string]float64
+	mu     sync.Mutex
+}
+
+// NewCounter creates a Counter. If label is empty, the counter has a single unlabelled value.
+func NewCounter(name string, help string, label string) *Counter {
+	return &Counter{
+		name:   name,
+		help:   help,
+		label:  label,
+		values: make(map[string]float64),
+		mu:     sync.Mutex{},
+	}
+}
+
+// Inc increments the counter by 1 for a label value
+func (c *Counter) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+// Add increments the counter by delta for a label value
+func (c *Counter) Add(labelValue string, delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[labelValue] += delta
+}
+
+func (c *Counter) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	labelValues := make([]string, 0, len(c.values))
+	for labelValue := range c.values {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+	for _, labelValue := range labelValues {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabel(c.label, labelValue), formatFloat(c.values[labelValue]))
+	}
+	if len(labelValues) == 0 && c.label == "" {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+}
+
+// Histogram samples observations into cumulative buckets
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+	mu      sync.Mutex
+}
+
+// NewHistogram creates a Histogram with the default latency buckets
+func NewHistogram(name string, help string) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		buckets: defaultBuckets,
+		counts:  make([]uint64, len(defaultBuckets)),
+		mu:      sync.Mutex{},
+	}
+}
+
+// Observe records a single observation
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i] += 1
+		}
+	}
+	h.sum += value
+	h.count += 1
+}
+
+// ObserveSince records the time elapsed since start in seconds
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// Metrics contains all the instrumentation of a replica
+type Metrics struct {
+	MessagesReceived     *Counter
+	MessagesSent         *Counter
+	PrepareCommitLatency *Histogram
+	QuorumWait           *Histogram
+	ViewChangesStarted   *Counter
+	ViewChangesCompleted *Counter
+	RecoveryDuration     *Histogram
+	CatchupBytes         *Counter
+	ClientRequestRetries *Counter
+	DigestMismatches     *Counter
+	MessagesRejected     *Counter
+	ClientRequestsDenied *Counter
+	timers               map[string]time.Time
+	mu                   sync.Mutex
+}
+
+// NewMetrics creates a new instance of Metrics with all values set to zero
+func NewMetrics() *Metrics {
+	return &Metrics{
+		MessagesReceived:     NewCounter("vsr_messages_received_total", "Messages received by message type.", "type"),
+		MessagesSent:         NewCounter("vsr_messages_sent_total", "Messages sent by message type.", "type"),
+		PrepareCommitLatency: NewHistogram("vsr_prepare_commit_latency_seconds", "Time from receiving a client request to committing it on the leader."),
+		QuorumWait:           NewHistogram("vsr_quorum_wait_seconds", "Time from broadcasting a prepare request to reaching a quorum of prepare responses."),
+		ViewChangesStarted:   NewCounter("vsr_view_changes_started_total", "View changes in which this replica took part.", ""),
+		ViewChangesCompleted: NewCounter("vsr_view_changes_completed_total", "View changes after which this replica returned to normal status.", ""),
+		RecoveryDuration:     NewHistogram("vsr_recovery_duration_seconds", "Time spent in recovering status while catching up with the leader."),
+		CatchupBytes:         NewCounter("vsr_catchup_bytes_total", "Bytes of catchup responses by direction.", "direction"),
+		ClientRequestRetries: NewCounter("vsr_client_request_retries_total", "Client requests received again for an already recorded request number.", ""),
+		DigestMismatches:     NewCounter("vsr_digest_mismatches_total", "Commit messages whose digest differed from the digest of this replica.", ""),
+		MessagesRejected:     NewCounter("vsr_messages_rejected_total", "Messages which failed authentication or came from an unexpected sender by reason.", "reason"),
+		ClientRequestsDenied: NewCounter("vsr_client_requests_denied_total", "Client requests rejected by access control by reason.", "reason"),
+		timers:               make(map[string]time.Time),
+		mu:                   sync.Mutex{},
+	}
+}
+
+// StartTimer records the current time against a key. It is a no-op if a timer is already running for the key.
+func (m *Metrics) StartTimer(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.timers[key]; !exists {
+		m.timers[key] = time.Now()
+	}
+}
+
+// StopTimer records the time elapsed since StartTimer was invoked for key in the histogram & clears the timer
+func (m *Metrics) StopTimer(key string, histogram *Histogram) {
+	m.mu.Lock()
+	start, exists := m.timers[key]
+	delete(m.timers, key)
+	m.mu.Unlock()
+
+	if exists {
+		histogram.ObserveSince(start)
+	}
+}
+
+// WriteTo writes all the metrics along with the replica status gauges in Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer, status ReplicaStatus) {
+	m.MessagesReceived.writeTo(w)
+	m.MessagesSent.writeTo(w)
+	m.PrepareCommitLatency.writeTo(w)
+	m.QuorumWait.writeTo(w)
+	m.ViewChangesStarted.writeTo(w)
+	m.ViewChangesCompleted.writeTo(w)
+	m.RecoveryDuration.writeTo(w)
+	m.CatchupBytes.writeTo(w)
+	m.ClientRequestRetries.writeTo(w)
+	m.DigestMismatches.writeTo(w)
+	m.MessagesRejected.writeTo(w)
+	m.ClientRequestsDenied.writeTo(w)
+	writeGauge(w, "vsr_log_length", "Number of entries in the replica log.", status.LogLength)
+	writeGauge(w, "vsr_view_number", "Current view number of the replica.", status.ViewNumber)
+	writeGauge(w, "vsr_operation_number", "Current operation number of the replica.", status.OperationNumber)
+	writeGauge(w, "vsr_commit_number", "Current commit number of the replica.", status.CommitNumber)
+}
+
+func writeGauge(w io.Writer, name string, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
+
+func formatLabel(label string, value string) string {
+	if label == "" {
+		return ""
+	}
+	return "{" + label + "=" + strconv.Quote(value) + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// Pause makes a replica behave as if it crashed for a while: it drops every message it receives & its timers do
+// nothing until the pause is over, after which it rejoins the cluster with the state it had. It is used to inject
+// failures of a replica through its admin endpoint.
+type Pause struct {
+	until time.Time
+	mu    sync.Mutex
+}
+
+// NewPause creates an instance of Pause which is not active
+func NewPause() *Pause {
+	return &Pause{
+		until: time.Time{},
+		mu:    sync.Mutex{},
+	}
+}
+
+// Start pauses the replica for the given duration, starting over if it is already paused
+func (pause *Pause) Start(duration time.Duration) {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+
+	pause.until = time.Now().Add(duration)
+}
+
+// Active returns true while the replica is paused
+func (pause *Pause) Active() bool {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+
+	return time.Now().Before(pause.until)
+}
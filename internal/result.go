@@ -0,0 +1,87 @@
+package internal
+
+import "strings"
+
+// OperationError is the error result of a client request. Code is the identifier sent to the client.
+type OperationError struct {
+	Code string
+}
+
+func (e *OperationError) Error() string {
+	return e.Code
+}
+
+var (
+	ErrInvalidDatabaseRequest  = &OperationError{Code: INVALID_DATABASE_REQUEST}
+	ErrValueDoesNotExist       = &OperationError{Code: VALUE_DOES_NOT_EXIST}
+	ErrValueNotAnInteger       = &OperationError{Code: VALUE_NOT_AN_INTEGER}
+	ErrIntegerOverflow         = &OperationError{Code: INTEGER_OVERFLOW}
+	ErrCompareFailed           = &OperationError{Code: COMPARE_FAILED}
+	ErrRevisionCompacted       = &OperationError{Code: REVISION_COMPACTED}
+	ErrLeaseNotFound           = &OperationError{Code: LEASE_NOT_FOUND}
+	ErrLockHeld                = &OperationError{Code: LOCK_HELD}
+	ErrLockNotHeld             = &OperationError{Code: LOCK_NOT_HELD}
+	ErrNonNumericRequestNumber = &OperationError{Code: SERVER_RESPONSE_NON_NUMERIC_REQUEST_NUMBER}
+	ErrInvalidRequestNumber    = &OperationError{Code: SERVER_RESPONSE_INVALID_REQUEST_NUMER}
+	ErrMalformedResponse       = &OperationError{Code: SERVER_RESPONSE_MALFORMED}
+	ErrSessionRequired         = &OperationError{Code: SERVER_RESPONSE_SESSION_REQUIRED}
+	ErrSessionExpired          = &OperationError{Code: SERVER_RESPONSE_SESSION_EXPIRED}
+	ErrUnauthenticated         = &OperationError{Code: SERVER_RESPONSE_UNAUTHENTICATED}
+	ErrPermissionDenied        = &OperationError{Code: SERVER_RESPONSE_PERMISSION_DENIED}
+	ErrMalformedRequest        = &OperationError{Code: SERVER_RESPONSE_MALFORMED_REQUEST}
+)
+
+var knownErrors = map[string]*OperationError{}
+
+func init() {
+	for _, err := range []*OperationError{
+		ErrInvalidDatabaseRequest,
+		ErrValueDoesNotExist,
+		ErrValueNotAnInteger,
+		ErrIntegerOverflow,
+		ErrCompareFailed,
+		ErrRevisionCompacted,
+		ErrLeaseNotFound,
+		ErrLockHeld,
+		ErrLockNotHeld,
+		ErrNonNumericRequestNumber,
+		ErrInvalidRequestNumber,
+		ErrSessionRequired,
+		ErrSessionExpired,
+		ErrUnauthenticated,
+		ErrPermissionDenied,
+		ErrMalformedRequest,
+	} {
+		knownErrors[err.Code] = err
+	}
+}
+
+// EncodeResult prepares the string representation of the result of a client request.
+// It is "ok <value>" on success & "error <code>" on failure.
+func EncodeResult(value string, err error) string {
+	if err != nil {
+		code := err.Error()
+		if opErr, ok := err.(*OperationError); ok {
+			code = opErr.Code
+		}
+		return RESULT_ERROR + " " + code
+	}
+	return RESULT_OK + " " + value
+}
+
+// DecodeResult parses a result prepared by EncodeResult. Error codes are mapped back to the matching
+// Err* value so that they can be compared with errors.Is.
+func DecodeResult(result string) (string, error) {
+	resultType, value, _ := strings.Cut(result, " ")
+	switch resultType {
+	case RESULT_OK:
+		return value, nil
+	case RESULT_ERROR:
+		if err, exists := knownErrors[value]; exists {
+			return "", err
+		}
+		return "", &OperationError{Code: value}
+	default:
+		return "", ErrMalformedResponse
+	}
+}
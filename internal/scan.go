@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// performScan lists keys in the range [start, end) in ascending order.
+// The result is described in formatScan.
+func (db *Database) performScan(splits []string) (string, error) {
+	if len(splits) != 4 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	limit, err := parseScanLimit(splits[3])
+	if err != nil {
+		return "", err
+	}
+	start, end := splits[1], splits[2]
+	if start == SCAN_UNBOUNDED {
+		start = ""
+	}
+	return db.formatScan(start, limit, func(key string) bool {
+		return end == SCAN_UNBOUNDED || key < end
+	}), nil
+}
+
+// performPrefix lists keys starting with a prefix in ascending order.
+// The result is described in formatScan.
+func (db *Database) performPrefix(splits []string) (string, error) {
+	if len(splits) != 3 {
+		return "", ErrInvalidDatabaseRequest
+	}
+	limit, err := parseScanLimit(splits[2])
+	if err != nil {
+		return "", err
+	}
+	prefix := splits[1]
+	return db.formatScan(prefix, limit, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}), nil
+}
+
+func parseScanLimit(limit string) (int, error) {
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return 0, ErrInvalidDatabaseRequest
+	}
+	return n, nil
+}
+
+// formatScan lists keys from start in ascending order while inRange holds for them.
+// The first line of the result contains the number of listed keys, followed by the key to start the next page
+// from if the listing was cut short by the limit or by SCAN_MAX_RESPONSE_LENGTH. Every following line contains
+// a key & its value separated by a space.
+func (db *Database) formatScan(start string, limit int, inRange func(key string) bool) string {
+	lines := make([]string, 0)
+	length, nextKey := 0, ""
+	db.store.Ascend(start, func(key string, entry dbEntry) bool {
+		if !inRange(key) {
+			return false
+		}
+		line := key + " " + entry.value
+		if len(lines) == limit || (len(lines) > 0 && length+len(line)+1 > SCAN_MAX_RESPONSE_LENGTH) {
+			nextKey = key
+			return false
+		}
+		lines = append(lines, line)
+		length += len(line) + 1
+		return true
+	})
+
+	header := strconv.Itoa(len(lines))
+	if nextKey != "" {
+		header += " " + nextKey
+	}
+	return strings.Join(append([]string{header}, lines...), "\n")
+}
+
+// Snapshot serializes the contents of the database. Keys are written in ascending order along with their
+// values, versions, revisions, ttls & leases, followed by the leases with their owners & the locks in ascending order, so replicas that applied the same operations produce byte-identical snapshots.
+func (db *Database) Snapshot() []byte {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snapshot := make([]byte, 0)
+	snapshot = binary.AppendUvarint(snapshot, uint64(db.store.Len()))
+	db.store.Ascend("", func(key string, entry dbEntry) bool {
+		snapshot = binary.AppendUvarint(snapshot, uint64(len(key)))
+		snapshot = append(snapshot, key...)
+		snapshot = binary.AppendUvarint(snapshot, uint64(len(entry.value)))
+		snapshot = append(snapshot, entry.value...)
+		snapshot = binary.AppendUvarint(snapshot, uint64(entry.version))
+		snapshot = binary.AppendUvarint(snapshot, uint64(entry.revision))
+		snapshot = binary.AppendUvarint(snapshot, uint64(entry.ttl))
+		snapshot = binary.AppendUvarint(snapshot, uint64(entry.lease))
+		return true
+	})
+	leaseIds := make([]int, 0, len(db.leases))
+	for id := range db.leases {
+		leaseIds = append(leaseIds, id)
+	}
+	sort.Ints(leaseIds)
+	snapshot = binary.AppendUvarint(snapshot, uint64(len(leaseIds)))
+	for _, id := range leaseIds {
+		snapshot = binary.AppendUvarint(snapshot, uint64(id))
+		snapshot = binary.AppendUvarint(snapshot, uint64(db.leases[id].ttl))
+		snapshot = binary.AppendUvarint(snapshot, uint64(db.leases[id].revision))
+		snapshot = binary.AppendUvarint(snapshot, uint64(db.leases[id].owner))
+	}
+	names := db.lockNames()
+	snapshot = binary.AppendUvarint(snapshot, uint64(len(names)))
+	for _, name := range names {
+		snapshot = binary.AppendUvarint(snapshot, uint64(len(name)))
+		snapshot = append(snapshot, name...)
+		snapshot = binary.AppendUvarint(snapshot, uint64(db.locks[name].limit))
+		holders := db.lockHolders(name)
+		snapshot = binary.AppendUvarint(snapshot, uint64(len(holders)))
+		snapshot = append(snapshot, holders...)
+	}
+	return snapshot
+}
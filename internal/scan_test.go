@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestScanPages(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "mset d 4 b 2 e 5 a 1 c 3")
+
+	if page := mustApply(t, db, "scan * * 2"); page != "2 c\na 1\nb 2" {
+		t.Errorf("first page = %q", page)
+	}
+	if page := mustApply(t, db, "scan c * 2"); page != "2 e\nc 3\nd 4" {
+		t.Errorf("second page = %q", page)
+	}
+	if page := mustApply(t, db, "scan e * 2"); page != "1\ne 5" {
+		t.Errorf("last page = %q", page)
+	}
+	if page := mustApply(t, db, "scan b d 10"); page != "2\nb 2\nc 3" {
+		t.Errorf("scan b d = %q, want b & c", page)
+	}
+	for _, operation := range []string{"scan * *", "scan * * 0", "scan * * x", "prefix a", "prefix a -1"} {
+		if _, err := applyNext(db, operation); err != ErrInvalidDatabaseRequest {
+			t.Errorf("Apply(%q) = %v, want %v", operation, err, ErrInvalidDatabaseRequest)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "mset user/2 bob user/1 alice users 2 use 0")
+	if page := mustApply(t, db, "prefix user/ 10"); page != "2\nuser/1 alice\nuser/2 bob" {
+		t.Errorf("prefix user/ = %q", page)
+	}
+}
+
+func TestScanFitsTheTransport(t *testing.T) {
+	db := NewDatabase()
+	value := strings.Repeat("v", SCAN_MAX_RESPONSE_LENGTH/3)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		mustApply(t, db, "set "+key+" "+value)
+	}
+	page := mustApply(t, db, "scan * * 100")
+	if len(page) > SCAN_MAX_RESPONSE_LENGTH+len("100 a\n") {
+		t.Errorf("page of %d bytes exceeds %d", len(page), SCAN_MAX_RESPONSE_LENGTH)
+	}
+	if header, _, _ := strings.Cut(page, "\n"); header != "2 c" {
+		t.Errorf("page header = %q, want 2 keys continued from c", header)
+	}
+}
+
+func TestSnapshotIsIndependentOfInsertionOrder(t *testing.T) {
+	keys := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		keys = append(keys, string(rune('a'+i%26))+strings.Repeat("x", i/26))
+	}
+	forward, backward := NewDatabase(), NewDatabase()
+	for i := range keys {
+		forward.Apply("set "+keys[i]+" "+keys[i], 1)
+		backward.Apply("set "+keys[len(keys)-1-i]+" "+keys[len(keys)-1-i], 1)
+	}
+	if !bytes.Equal(forward.Snapshot(), backward.Snapshot()) {
+		t.Error("snapshots differ for the same keys inserted in another order")
+	}
+
+	mustApply(t, backward, "set a again")
+	if bytes.Equal(forward.Snapshot(), backward.Snapshot()) {
+		t.Error("snapshots are equal after a key was updated")
+	}
+}
@@ -9,10 +9,25 @@ import (
 )
 
 // ClientTableValue contains the client request, the number associated with the requested & response associated with the request if it is processed.
+// Port is the port the response is sent to. It is only known to the leader which received the request & is 0 otherwise.
 type ClientTableValue struct {
 	Request       string
 	RequestNumber int
 	Response      string
+	Port          int
+}
+
+// ReplicaStatus is a point in time view of a replica's state, exposed through the admin endpoint
+type ReplicaStatus struct {
+	Port            int    `json:"port"`
+	ReplicaNumber   int    `json:"replica_number"`
+	ViewNumber      int    `json:"view_number"`
+	Status          string `json:"status"`
+	OperationNumber int    `json:"operation_number"`
+	CommitNumber    int    `json:"commit_number"`
+	LogLength       int    `json:"log_length"`
+	LeaderPort      int    `json:"leader_port"`
+	Digest          string `json:"digest"`
 }
 
 type doViewChange struct {
@@ -27,20 +42,28 @@ type doViewChange struct {
 // - configuration: Sorted array containing ports of all replicas
 // - viewNumber: current view number
 // - status: current status associated with the replica
+// - lastNormalView: latest view in which the replica had normal status
 // - operationNumber: monotonically increasing counter associated to each request
 // - log: an array containing all requests. Size of log is same as that of operationNumber
 // - commitNumber: operationNumber associated with most recently committed operation
-// - clientTable: A hashmap to record the latest ClientTableValue for each client
+// - responses: response of every committed operation, in the order of operation numbers
+// - digests: rolling digest of the committed operations at every commit number
+// - clientTable: A hashmap to record the latest ClientTableValue for each client, keyed by its session id
+// - sessions: set of the ids of registered client sessions
 // - replicaNumber: index of replica in the configuration
-// - voteTable: A hashmap for recording votes for each client request. This is used to establish quorum for a client request
+// - voteTable: A hashmap for recording votes for each operation number. This is used to establish quorum for a client request
 type ServerState struct {
 	configuration   []int
 	viewNumber      int
 	status          string
+	lastNormalView  int
 	operationNumber int
 	log             []string
 	commitNumber    int
+	responses       []string
+	digests         []string
 	clientTable     map[int]ClientTableValue
+	sessions        map[int]bool
 	replicaNumber   int
 	voteTable       map[int]map[int]bool
 	viewChangeMap   map[int][]int
@@ -58,31 +81,62 @@ func NewServerState(port int) *ServerState {
 			replicaNumber = i
 		}
 	}
-	return &ServerState{
+	state := &ServerState{
 		configuration:   configuration[:],
 		viewNumber:      0,
 		status:          NORMAL,
+		lastNormalView:  0,
 		operationNumber: 0,
 		log:             make([]string, 0),
 		commitNumber:    0,
+		responses:       make([]string, 0),
+		digests:         make([]string, 0),
 		clientTable:     make(map[int]ClientTableValue),
+		sessions:        make(map[int]bool),
 		replicaNumber:   replicaNumber,
 		voteTable:       make(map[int]map[int]bool),
 		viewChangeMap:   map[int][]int{},
 		doViewChangeMap: make(map[int]doViewChange),
 		mu:              sync.Mutex{},
 	}
+	state.resetInvariants()
+	return state
+}
+
+// GetReplicaStatus returns a snapshot of the replica's state for reporting purposes
+func (state *ServerState) GetReplicaStatus() ReplicaStatus {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return ReplicaStatus{
+		Port:            state.configuration[state.replicaNumber],
+		ReplicaNumber:   state.replicaNumber,
+		ViewNumber:      state.viewNumber,
+		Status:          state.status,
+		OperationNumber: state.operationNumber,
+		CommitNumber:    state.commitNumber,
+		LogLength:       len(state.log),
+		LeaderPort:      state.configuration[state.viewNumber%NUMBER_OF_NODES],
+		Digest:          state.latestDigest(),
+	}
 }
 
 // GetClientTableValue retrieves ClientTableValue for a client
-func (state *ServerState) GetClientTableValue(port int) (ClientTableValue, bool) {
-	val, exists := state.clientTable[port]
+func (state *ServerState) GetClientTableValue(clientId int) (ClientTableValue, bool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	val, exists := state.clientTable[clientId]
 	return val, exists
 }
 
 // RecordRequest updates the server state for a new client request.
-// It is invoked either by leader replica for processing new client request or by replica nodes while processing PrepareRequest from leader node
-func (state *ServerState) RecordRequest(command string, requestNumber int, port int) {
+// It is invoked either by leader replica for processing new client request or by replica nodes while processing PrepareRequest from leader node.
+// port is the port to respond to, which is 0 for replica nodes. It returns the operation number of the request.
+func (state *ServerState) RecordRequest(command string, requestNumber int, clientId int, port int) int {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	// Increment operation number
 	state.operationNumber += 1
 	// Add request to log
@@ -91,7 +145,7 @@ func (state *ServerState) RecordRequest(command string, requestNumber int, port
 		Append(LOG_DELIMETER).
 		AppendInt(requestNumber).
 		Append(LOG_DELIMETER).
-		AppendInt(port).
+		AppendInt(clientId).
 		ToString()
 	state.log = append(state.log, entry)
 	// Update client table
@@ -99,48 +153,180 @@ func (state *ServerState) RecordRequest(command string, requestNumber int, port
 		Request:       command,
 		RequestNumber: requestNumber,
 		Response:      "",
+		Port:          port,
 	}
-	state.clientTable[port] = *ctValue
+	state.clientTable[clientId] = *ctValue
+	state.checkInvariants(MUTATION_RECORD_REQUEST)
+	return state.operationNumber
+}
+
+// RecordClientPort records the port to respond to for the pending request of a client. It is used by a new leader
+// for requests which were received by a previous leader & are retried by the client.
+func (state *ServerState) RecordClientPort(clientId int, port int) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if ctValue, exists := state.clientTable[clientId]; exists {
+		ctValue.Port = port
+		state.clientTable[clientId] = ctValue
+	}
+}
+
+// logEscaper & logUnescaper escape LOGS_DELIMETER in log entries, whose commands may contain any character but DELIMETER
+var (
+	logEscaper   = strings.NewReplacer("%", "%25", LOGS_DELIMETER, "%2C")
+	logUnescaper = strings.NewReplacer("%2C", LOGS_DELIMETER, "%25", "%")
+)
+
+// joinLogs joins log entries with LOGS_DELIMETER for catchup & view change messages
+func joinLogs(logs []string) string {
+	escaped := make([]string, len(logs))
+	for i, log := range logs {
+		escaped[i] = logEscaper.Replace(log)
+	}
+	return strings.Join(escaped, LOGS_DELIMETER)
+}
+
+// parseLogEntry splits a log entry into its command, request number & client id.
+// The command may itself contain LOG_DELIMETER (e.g. "incr key -1"), hence the entry is split from the right.
+func parseLogEntry(entry string) (string, int, int) {
+	rest, portPart, _ := cutLast(entry, LOG_DELIMETER)
+	command, requestNumberPart, _ := cutLast(rest, LOG_DELIMETER)
+	requestNumber, _ := strconv.Atoi(requestNumberPart)
+	port, _ := strconv.Atoi(portPart)
+	return command, requestNumber, port
+}
+
+// cutLast slices s around the last instance of sep. If sep does not appear in s, it returns s, "", false.
+func cutLast(s string, sep string) (string, string, bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
 }
 
 // Broadcast is invoked by the leader node to send a message to all peer nodes except itself.
-func (state *ServerState) Broadcast(message string, udpHandler *UdpHandler) {
+func (state *ServerState) Broadcast(message string, transport Transport) {
 	for i := 0; i < NUMBER_OF_NODES; i++ {
 		if i != state.replicaNumber {
-			udpHandler.Send(message, STARTING_PORT+i)
+			transport.Send(message, STARTING_PORT+i)
 		}
 	}
 }
 
-// InitializeVoteTable initializes a map with key equal to port of client.
+// InitializeVoteTable initializes a map with key equal to operation number.
 // This is done to calcualte quorum for a client operation from other replica nodes.
-func (state *ServerState) InitializeVoteTable(clientPort int) {
+func (state *ServerState) InitializeVoteTable(operationNumber int) {
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
-	state.voteTable[clientPort] = make(map[int]bool)
+	state.voteTable[operationNumber] = make(map[int]bool)
 }
 
-// RecordPrepareResponse records the response from a replica node & returns a boolean value representing if quorum has been reached
-func (state *ServerState) RecordPrepareResponse(port int, replicaId int) bool {
+// ResetVoteTable drops the votes of all operations. It is invoked by a replica when it becomes the leader.
+func (state *ServerState) ResetVoteTable() {
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
-	state.voteTable[port][replicaId] = true
-	return len(state.voteTable[port]) >= NUMBER_OF_NODES/2
+	state.voteTable = make(map[int]map[int]bool)
 }
 
-// RecordCommit commits the client operation & updates the client table with response
-func (state *ServerState) RecordCommit(port int, response string) {
+// RecordPrepareResponse records the response from a replica node & returns a boolean value representing if quorum has been reached.
+// Responses for operations which are already committed are ignored.
+func (state *ServerState) RecordPrepareResponse(operationNumber int, replicaId int) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if operationNumber <= state.commitNumber || operationNumber > state.operationNumber {
+		return false
+	}
+	if _, exists := state.voteTable[operationNumber]; !exists {
+		state.voteTable[operationNumber] = make(map[int]bool)
+	}
+	state.voteTable[operationNumber][replicaId] = true
+	return len(state.voteTable[operationNumber]) >= NUMBER_OF_NODES/2
+}
+
+// RecordCommit commits the next operation & records its response. The client table is updated with the response
+// unless the client has sent a later request since.
+func (state *ServerState) RecordCommit(clientId int, requestNumber int, response string) {
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
 	// increment commit number
 	state.IncrementCommitNumber()
+	state.digests = append(state.digests, nextDigest(state.latestDigest(), state.log[state.commitNumber-1], response))
+	state.responses = append(state.responses, response)
+	delete(state.voteTable, state.commitNumber)
 	// update client table
-	ctValue := state.clientTable[port]
-	ctValue.Response = response
-	state.clientTable[port] = ctValue
+	ctValue, exists := state.clientTable[clientId]
+	if exists && ctValue.RequestNumber == requestNumber {
+		ctValue.Response = response
+		state.clientTable[clientId] = ctValue
+	}
+	state.checkInvariants(MUTATION_RECORD_COMMIT)
+}
+
+// LogEntryMatches returns true if the log entry at an operation number is the given request of a client
+func (state *ServerState) LogEntryMatches(operationNumber int, requestNumber int, clientId int) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if operationNumber < 1 || operationNumber > len(state.log) {
+		return false
+	}
+	_, entryRequestNumber, entryClientId := parseLogEntry(state.log[operationNumber-1])
+	return entryRequestNumber == requestNumber && entryClientId == clientId
+}
+
+// TruncateLog drops the entries of the log after an operation number, which must not be below the commit number
+func (state *ServerState) TruncateLog(operationNumber int) {
+	state.mu.Lock()
+	state.log = state.log[:operationNumber]
+	state.operationNumber = operationNumber
+	state.checkInvariants(MUTATION_TRUNCATE_LOG)
+	state.mu.Unlock()
+
+	state.RebuildClientTable()
+}
+
+// RebuildClientTable reconstructs the client table & the sessions from the log after it is replaced by a view change.
+// Committed operations carry their recorded response, so a client retrying a request after a view change gets the
+// original response instead of the request being executed again. Ports of pending requests known to the leader are kept.
+func (state *ServerState) RebuildClientTable() {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	previous := state.clientTable
+	state.clientTable = make(map[int]ClientTableValue)
+	state.sessions = make(map[int]bool)
+	for i, entry := range state.log {
+		command, requestNumber, clientId := parseLogEntry(entry)
+		response := ""
+		if i < state.commitNumber {
+			if isSessionOperation(command) {
+				state.applySessionOperation(command, i+1)
+			}
+			response = state.responses[i]
+		}
+		ctValue := ClientTableValue{
+			Request:       command,
+			RequestNumber: requestNumber,
+			Response:      response,
+		}
+		if value, exists := previous[clientId]; exists && value.RequestNumber == requestNumber {
+			ctValue.Port = value.Port
+		}
+		state.clientTable[clientId] = ctValue
+	}
+}
+
+// latestDigest returns the digest at the commit number
+func (state *ServerState) latestDigest() string {
+	if len(state.digests) == 0 {
+		return ""
+	}
+	return state.digests[len(state.digests)-1]
 }
 
 // IncrementCommitNumber increments the commit number for server state by 1
@@ -149,37 +335,35 @@ func (state *ServerState) IncrementCommitNumber() {
 }
 
 // RecordViewChange keeps track of start view change messages & for calculating quorum on how many
-// replicas are in agreement that current leader is down.
+// replicas are in agreement that current leader is down. It returns true only for the message which completes the
+// quorum, so that a replica sends its do_view_change once per view.
 func (state *ServerState) RecordViewChange(port int, viewNumber int) bool {
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
+	for _, recorded := range state.viewChangeMap[viewNumber] {
+		if recorded == port {
+			return false
+		}
+	}
 	state.viewChangeMap[viewNumber] = append(state.viewChangeMap[viewNumber], port)
-	return len(state.viewChangeMap[viewNumber]) >= NUMBER_OF_NODES/2
+	return len(state.viewChangeMap[viewNumber]) == NUMBER_OF_NODES/2
 }
 
 // RecordDoViewChange records the response from replica to the next node in configuration.
-// Once the next node in order gets a quorum for do_view_change, it can promote itself to leader
-func (state *ServerState) RecordDoViewChange(message string, port int) bool {
+// Once the next node in order gets a quorum for do_view_change, including its own, it can promote itself to leader.
+// Responses recorded for any other view are dropped.
+func (state *ServerState) RecordDoViewChange(doViewChange *doViewChange, port int) bool {
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
-	parts := strings.Split(message, DELIMETER)
-
-	oldViewNumber, _ := strconv.Atoi(parts[1])
-	newViewNumber, _ := strconv.Atoi(parts[2])
-	operationNumber, _ := strconv.Atoi(parts[3])
-	commitNumber, _ := strconv.Atoi(parts[4])
-	logs := strings.Split(parts[5], ",")
-	doViewChange := &doViewChange{
-		oldViewNumber:   oldViewNumber,
-		newViewNumber:   newViewNumber,
-		operationNumber: operationNumber,
-		commitNumber:    commitNumber,
-		logs:            logs,
+	for recordedPort, recorded := range state.doViewChangeMap {
+		if recorded.newViewNumber != doViewChange.newViewNumber {
+			delete(state.doViewChangeMap, recordedPort)
+		}
 	}
 	state.doViewChangeMap[port] = *doViewChange
-	return len(state.doViewChangeMap) >= NUMBER_OF_NODES/2
+	return len(state.doViewChangeMap) > NUMBER_OF_NODES/2
 }
 
 // UpdateForNewView updates the state for newly elected leader replica.
@@ -210,41 +394,89 @@ func (state *ServerState) UpdateForNewView() []string {
 	}
 	// list the logs that need to be committed by comparing the commit number
 	state.log = state.doViewChangeMap[nodeWithHighestOpNumber].logs
+	if len(state.log) == 1 && state.log[0] == "" {
+		state.log = make([]string, 0)
+	}
 	// change view number & operation number
 	state.viewNumber = state.doViewChangeMap[nodeWithHighestOpNumber].newViewNumber
 	state.operationNumber = state.doViewChangeMap[nodeWithHighestOpNumber].operationNumber
 	// return logs to be committed
 	latestCommitNumber := state.doViewChangeMap[nodeWithHighestOpNumber].commitNumber
 	uncommittedLogs := make([]string, 0)
-	if state.commitNumber+1 < latestCommitNumber {
-		uncommittedLogs = state.log[state.commitNumber+1 : latestCommitNumber]
+	if state.commitNumber < latestCommitNumber {
+		uncommittedLogs = state.log[state.commitNumber:latestCommitNumber]
 	}
 	// reset do view change map
 	state.doViewChangeMap = make(map[int]doViewChange)
+	state.checkInvariants(MUTATION_UPDATE_FOR_NEW_VIEW)
 
 	return uncommittedLogs
 }
 
-// UpdateView updates the state for a replica node whenever a view change occurs
-func (state *ServerState) UpdateView(operationNumber int, viewNumber int, commitNumber int, logs []string) {
+// UpdateView updates the state for a replica node whenever a view change occurs.
+// The commit number is left as is, as the replica is yet to execute the operations committed in the new view.
+func (state *ServerState) UpdateView(operationNumber int, viewNumber int, logs []string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	state.viewNumber = viewNumber
 	state.operationNumber = operationNumber
-	state.commitNumber = commitNumber
+	if len(logs) == 1 && logs[0] == "" {
+		logs = make([]string, 0)
+	}
 	state.log = logs
+	state.checkInvariants(MUTATION_UPDATE_VIEW)
+}
+
+// UpdateViewNumber moves a replica to a later view without changing its status
+func (state *ServerState) UpdateViewNumber(viewNumber int) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.viewNumber = viewNumber
+}
+
+// StartViewChange moves a replica to a later view with the view change status
+func (state *ServerState) StartViewChange(viewNumber int) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.viewNumber = viewNumber
+	state.status = VIEW_CHANGE
 }
 
 // UpdateStatus is responsible for setting the status of the server to a given string
 func (state *ServerState) UpdateStatus(status string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	state.status = status
+	if status == NORMAL {
+		state.lastNormalView = state.viewNumber
+	}
+}
+
+// GetViewNumber returns the current view number of the server
+func (state *ServerState) GetViewNumber() int {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return state.viewNumber
 }
 
 // GetStatus returns the current status of the server
 func (state *ServerState) GetStatus() string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	return state.status
 }
 
 // BuildPrepareResponse prepares a string representation for response of PrepareRequest
 func (state *ServerState) BuildPrepareResponse(operationNumber int, port int) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
 
 	return sb.Append(PREPARE_RESPONSE_PREFIX).
@@ -261,6 +493,9 @@ func (state *ServerState) BuildPrepareResponse(operationNumber int, port int) st
 
 // BuildPrepareRequest prepares a string represenatation of leader node's prepare request
 func (state *ServerState) BuildPrepareRequest(command string, requestNumber int, port int) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
 
 	return sb.Append(PREPARE_REQUEST_PREFIX).
@@ -279,22 +514,37 @@ func (state *ServerState) BuildPrepareRequest(command string, requestNumber int,
 		ToString()
 }
 
-// BuildCommitMessage prepares a string representation of leader node's commit message
-func (state *ServerState) BuildCommitMessage(requestNumber int, port int) string {
+// BuildCommitMessage prepares a string representation of leader node's commit message.
+// It carries the commit number along with the request number & client of the operation at the commit number & the digest.
+func (state *ServerState) BuildCommitMessage() string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
+	requestNumber, clientId := 0, 0
+	if state.commitNumber > 0 {
+		_, requestNumber, clientId = parseLogEntry(state.log[state.commitNumber-1])
+	}
 
 	return sb.Append(COMMIT_MESSAGE_PREFIX).
 		Append(DELIMETER).
 		Append(strconv.Itoa(state.viewNumber)).
 		Append(DELIMETER).
+		Append(strconv.Itoa(state.commitNumber)).
+		Append(DELIMETER).
 		Append(strconv.Itoa(requestNumber)).
 		Append(DELIMETER).
-		Append(strconv.Itoa(port)).
+		Append(strconv.Itoa(clientId)).
+		Append(DELIMETER).
+		Append(state.latestDigest()).
 		ToString()
 }
 
 // BuildCatchupRequest prepares a string representation of replica node's catchup request
 func (state *ServerState) BuildCatchupRequest(operationNumber int) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
 
 	return sb.Append(CATCHUP_REQUEST_PREFIX).
@@ -307,19 +557,27 @@ func (state *ServerState) BuildCatchupRequest(operationNumber int) string {
 
 // BuildCatchupResponse prepares a string representation of catchup response
 func (state *ServerState) BuildCatchupResponse(replicaOperationNumber int, laggingOperationNumber int) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
+	laggingOperationNumber = max(1, min(laggingOperationNumber, len(state.log)+1))
+	replicaOperationNumber = max(0, min(replicaOperationNumber, laggingOperationNumber-1))
 
 	return sb.
 		Append(CATCHUP_RESPONSE_PREFIX).
 		Append(DELIMETER).
 		AppendInt(state.commitNumber).
 		Append(DELIMETER).
-		Append(strings.Join(state.log[replicaOperationNumber:laggingOperationNumber-1], ",")).
+		Append(joinLogs(state.log[replicaOperationNumber : laggingOperationNumber-1])).
 		ToString()
 }
 
 // BuildStartViewChangeRequest prepares a string representation of start view change request
 func (state *ServerState) BuildStartViewChangeRequest() string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
 
 	return sb.Append(START_VIEW_CHANGE_PREFIX).
@@ -328,13 +586,17 @@ func (state *ServerState) BuildStartViewChangeRequest() string {
 		ToString()
 }
 
-// BuildDoViewChangeRequest prepares a string representation of do view change request
-func (state *ServerState) BuildDoViewChangeRequest(oldViewNumber int, newViewNumber int) string {
+// BuildDoViewChangeRequest prepares a string representation of do view change request.
+// It carries the latest view in which the replica had normal status as the old view number.
+func (state *ServerState) BuildDoViewChangeRequest(newViewNumber int) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
 
 	return sb.Append(DO_VIEW_CHANGE_PREFIX).
 		Append(DELIMETER).
-		AppendInt(oldViewNumber).
+		AppendInt(state.lastNormalView).
 		Append(DELIMETER).
 		AppendInt(newViewNumber).
 		Append(DELIMETER).
@@ -342,12 +604,15 @@ func (state *ServerState) BuildDoViewChangeRequest(oldViewNumber int, newViewNum
 		Append(DELIMETER).
 		AppendInt(state.commitNumber).
 		Append(DELIMETER).
-		Append(strings.Join(state.log, ",")).
+		Append(joinLogs(state.log)).
 		ToString()
 }
 
 // BuildStartViewRequest prepares a string representation of start view request
 func (state *ServerState) BuildStartViewRequest() string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
 
 	return sb.Append(START_VIEW_PREFIX).
@@ -358,18 +623,38 @@ func (state *ServerState) BuildStartViewRequest() string {
 		Append(DELIMETER).
 		AppendInt(state.commitNumber).
 		Append(DELIMETER).
-		Append(strings.Join(state.log, ",")).
+		Append(joinLogs(state.log)).
 		ToString()
 }
 
-// BuildClientResponse prepares a string representation of client response
-func (state *ServerState) BuildClientResponse(response string) string {
+// BuildClientResponse prepares a string representation of the response to the client request with a request number
+func (state *ServerState) BuildClientResponse(requestNumber int, response string) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
 	sb := Text.StringBuilder{}
 
 	return sb.Append(SERVER_RESPONSE_PREFIX).
 		Append(DELIMETER).
 		AppendInt(state.viewNumber).
 		Append(DELIMETER).
+		AppendInt(requestNumber).
+		Append(DELIMETER).
 		Append(response).
 		ToString()
 }
+
+// BuildWatchEvent prepares a string representation of a change sent to a watching client
+func (state *ServerState) BuildWatchEvent(event ChangeEvent) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	sb := Text.StringBuilder{}
+
+	return sb.Append(WATCH_EVENT_PREFIX).
+		Append(DELIMETER).
+		AppendInt(state.viewNumber).
+		Append(DELIMETER).
+		Append(event.String()).
+		ToString()
+}
@@ -12,7 +12,20 @@ type ServerTimeout struct {
 func NewServerTimeout(timeoutInterval int) *ServerTimeout {
 	return &ServerTimeout{
 		Timeout:         time.NewTicker(time.Duration(timeoutInterval) * time.Millisecond),
-		Reset:           make(chan struct{}),
+		Reset:           make(chan struct{}, 1),
 		TimeoutInterval: timeoutInterval,
 	}
 }
+
+// ResetTimeout requests the timer to start over. It never blocks: if a reset is already pending, the request is dropped.
+func (timeout *ServerTimeout) ResetTimeout() {
+	select {
+	case timeout.Reset <- struct{}{}:
+	default:
+	}
+}
+
+// Stop turns off the underlying ticker
+func (timeout *ServerTimeout) Stop() {
+	timeout.Timeout.Stop()
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isSessionOperation returns true for operations which are applied on the client table instead of the database
+func isSessionOperation(operation string) bool {
+	fields := strings.Fields(operation)
+	return len(fields) > 0 && (fields[0] == REGISTER_REQUEST || fields[0] == SESSION_REQUEST)
+}
+
+// ApplySessionOperation applies a committed session operation at a revision & returns the encoded result:
+// - register: creates a session & returns its id, which is SESSION_ID_OFFSET + the revision of the operation
+// - session expire id [id ...]: removes the sessions & their client table entries. It is proposed by the leader
+// for clients which have been idle for SESSION_TIMEOUT. The leases of the sessions are revoked by RevokeSessionLeases
+// Ids of sessions are never below SESSION_ID_OFFSET, so they do not collide with the ports of clients which are
+// yet to register & of replicas proposing operations of their own.
+func (state *ServerState) ApplySessionOperation(operation string, revision int) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return state.applySessionOperation(operation, revision)
+}
+
+func (state *ServerState) applySessionOperation(operation string, revision int) string {
+	fields := strings.Fields(operation)
+	if len(fields) == 1 && fields[0] == REGISTER_REQUEST {
+		sessionId := SESSION_ID_OFFSET + revision
+		state.sessions[sessionId] = true
+		// the entry lets an unused session expire. Request numbers of the client continue from its registration.
+		state.clientTable[sessionId] = ClientTableValue{Request: REGISTER_REQUEST, RequestNumber: -1, Response: EncodeResult(strconv.Itoa(sessionId), nil)}
+		return EncodeResult(strconv.Itoa(sessionId), nil)
+	}
+	ids, err := parseSessionExpire(operation)
+	if err != nil {
+		return EncodeResult("", err)
+	}
+	for _, id := range ids {
+		delete(state.sessions, id)
+		delete(state.clientTable, id)
+	}
+	return EncodeResult(strconv.Itoa(len(ids)), nil)
+}
+
+// parseSessionExpire returns the ids of the sessions expired by "session expire id [id ...]"
+func parseSessionExpire(operation string) ([]int, error) {
+	fields := strings.Fields(operation)
+	if len(fields) < 3 || fields[0] != SESSION_REQUEST || fields[1] != "expire" {
+		return nil, ErrInvalidDatabaseRequest
+	}
+	ids := make([]int, 0, len(fields)-2)
+	for _, field := range fields[2:] {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, ErrInvalidDatabaseRequest
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// HasSession returns true if a session is registered & has not expired
+func (state *ServerState) HasSession(sessionId int) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return state.sessions[sessionId]
+}
+
+// ClientExpirables returns an Expirable for every client in the client table except the replicas themselves &
+// clients with a request in flight. The ttl of a client runs from its latest request, tracked through its request number.
+func (state *ServerState) ClientExpirables() []Expirable {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	expirables := make([]Expirable, 0)
+	for clientId, value := range state.clientTable {
+		if isReplicaPort(clientId) || value.Response == "" {
+			continue
+		}
+		expirables = append(expirables, Expirable{
+			ClientId: clientId,
+			Revision: value.RequestNumber,
+			TTL:      SESSION_TIMEOUT * time.Millisecond,
+		})
+	}
+	return expirables
+}
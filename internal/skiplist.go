@@ -0,0 +1,119 @@
+package internal
+
+import "math/rand"
+
+const (
+	SKIPLIST_MAX_LEVEL = 24
+	// levels are drawn from a fixed seed so that every replica builds the same structure for the same operations
+	SKIPLIST_SEED = 1
+)
+
+type skiplistNode struct {
+	key   string
+	entry dbEntry
+	next  []*skiplistNode
+}
+
+// skiplist is an ordered map from keys to database entries. Iteration is always in ascending order of keys.
+type skiplist struct {
+	head   *skiplistNode
+	level  int
+	length int
+	random *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:   &skiplistNode{next: make([]*skiplistNode, SKIPLIST_MAX_LEVEL)},
+		level:  1,
+		length: 0,
+		random: rand.New(rand.NewSource(SKIPLIST_SEED)),
+	}
+}
+
+// Get returns the entry for a key & whether it exists
+func (s *skiplist) Get(key string) (dbEntry, bool) {
+	node := s.seek(key, nil)
+	if node != nil && node.key == key {
+		return node.entry, true
+	}
+	return dbEntry{}, false
+}
+
+// Put inserts or replaces the entry for a key
+func (s *skiplist) Put(key string, entry dbEntry) {
+	update := make([]*skiplistNode, SKIPLIST_MAX_LEVEL)
+	node := s.seek(key, update)
+	if node != nil && node.key == key {
+		node.entry = entry
+		return
+	}
+
+	level := s.randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+	node = &skiplistNode{key: key, entry: entry, next: make([]*skiplistNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	s.length += 1
+}
+
+// Delete removes a key. It returns false if the key does not exist.
+func (s *skiplist) Delete(key string) bool {
+	update := make([]*skiplistNode, SKIPLIST_MAX_LEVEL)
+	node := s.seek(key, update)
+	if node == nil || node.key != key {
+		return false
+	}
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level -= 1
+	}
+	s.length -= 1
+	return true
+}
+
+// Len returns the number of keys
+func (s *skiplist) Len() int {
+	return s.length
+}
+
+// Ascend calls fn for every key greater than or equal to start in ascending order until fn returns false
+func (s *skiplist) Ascend(start string, fn func(key string, entry dbEntry) bool) {
+	for node := s.seek(start, nil); node != nil; node = node.next[0] {
+		if !fn(node.key, node.entry) {
+			return
+		}
+	}
+}
+
+// seek returns the first node with a key greater than or equal to key. If update is not nil,
+// it is filled with the rightmost node before key on every level.
+func (s *skiplist) seek(key string, update []*skiplistNode) *skiplistNode {
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		if update != nil {
+			update[i] = node
+		}
+	}
+	return node.next[0]
+}
+
+func (s *skiplist) randomLevel() int {
+	level := 1
+	for level < SKIPLIST_MAX_LEVEL && s.random.Intn(4) == 0 {
+		level += 1
+	}
+	return level
+}
@@ -0,0 +1,296 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TlsTransport sends messages over TLS streams with mutual certificate authentication. Every node listens on its port
+// & sends to a peer over a connection it dials to the port of the peer, so that every connection carries messages in
+// one direction. The sender of a message is the port in the name of the certificate of the connection, which is
+// checked against the cluster configuration. Connections from peers with any other certificate are rejected.
+// Messages to a peer are queued & sent by a goroutine per peer, so a peer which is down delays no other peer.
+// Like datagrams, messages which cannot be sent are dropped. Nodes are reached at their address, which is their port
+// on 127.0.0.1 unless configured otherwise.
+type TlsTransport struct {
+	listener     net.Listener
+	clientConfig *tls.Config
+	addresses    map[int]string
+	auth         *Authenticator
+	logger       *slog.Logger
+	incoming     chan UdpMessage
+	peers        map[int]chan string
+	conns        map[net.Conn]bool
+	done         chan struct{}
+	closeOnce    sync.Once
+	mu           sync.Mutex
+}
+
+// NewTlsTransport creates an instance of TlsTransport of the node on a port, which logs rejected peers through the
+// given logger. Nodes are reached at the host:port they are mapped to in addresses, as parsed by ParseAddresses, or
+// else at their port on 127.0.0.1. The transport listens on the port of the address of its own node on every interface.
+// The certificate of the node & the certificate authority are loaded from files in certDir, as generated by
+// GenerateCertificates. It returns an error if the certificates cannot be loaded or the port is in use.
+func NewTlsTransport(port int, certDir string, addresses map[int]string, logger *slog.Logger) (*TlsTransport, error) {
+	certificate, err := tls.LoadX509KeyPair(certPath(certDir, nodeName(port)), keyPath(certDir, nodeName(port)))
+	if err != nil {
+		return nil, err
+	}
+	caPem, err := os.ReadFile(certPath(certDir, TLS_CA_FILE))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPem) {
+		return nil, errors.New("no certificate found in " + certPath(certDir, TLS_CA_FILE))
+	}
+
+	_, listenPort, err := net.SplitHostPort(nodeAddress(addresses, port))
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", ":"+listenPort, &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	})
+	if err != nil {
+		return nil, err
+	}
+	transport := &TlsTransport{
+		listener: listener,
+		clientConfig: &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			RootCAs:      pool,
+			MinVersion:   tls.VersionTLS13,
+		},
+		addresses: addresses,
+		logger:    logger.With("transport", "tls", "port", port),
+		incoming:  make(chan UdpMessage, TLS_SEND_QUEUE_LENGTH),
+		peers:     make(map[int]chan string),
+		conns:     make(map[net.Conn]bool),
+		done:      make(chan struct{}),
+		mu:        sync.Mutex{},
+	}
+	go transport.accept()
+	return transport, nil
+}
+
+// Send queues a message to the node on a port. It returns an error if the transport is closed or the queue of the
+// peer is full, in which case the message is dropped.
+func (t *TlsTransport) Send(message string, port int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return net.ErrClosed
+	default:
+	}
+	queue, exists := t.peers[port]
+	if !exists {
+		queue = make(chan string, TLS_SEND_QUEUE_LENGTH)
+		t.peers[port] = queue
+		go t.sendTo(port, queue)
+	}
+	select {
+	case queue <- t.auth.Seal(message):
+		return nil
+	default:
+		return errors.New("send queue of " + nodeName(port) + " is full")
+	}
+}
+
+// Receive waits for the next message from any peer
+func (t *TlsTransport) Receive() (UdpMessage, error) {
+	select {
+	case message := <-t.incoming:
+		return message, nil
+	case <-t.done:
+		return UdpMessage{}, net.ErrClosed
+	}
+}
+
+// RecieveWithTimeout waits for the next message from any peer for a duration. It returns os.ErrDeadlineExceeded,
+// which is a timeout net.Error, if no message is received in time.
+func (t *TlsTransport) RecieveWithTimeout(timeout time.Duration) (UdpMessage, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case message := <-t.incoming:
+		return message, nil
+	case <-timer.C:
+		return UdpMessage{}, os.ErrDeadlineExceeded
+	case <-t.done:
+		return UdpMessage{}, net.ErrClosed
+	}
+}
+
+// SetAuthenticator sets the Authenticator used to sign sent messages
+func (t *TlsTransport) SetAuthenticator(auth *Authenticator) {
+	t.auth = auth
+}
+
+// Close stops accepting connections & closes the connections from peers. Queued messages are dropped.
+func (t *TlsTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		close(t.done)
+		err = t.listener.Close()
+		for conn := range t.conns {
+			conn.Close()
+		}
+	})
+	return err
+}
+
+// accept accepts connections from peers until the transport is closed
+func (t *TlsTransport) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			default:
+				continue
+			}
+		}
+		go t.receiveFrom(conn.(*tls.Conn))
+	}
+}
+
+// receiveFrom authenticates a peer by its certificate & then delivers the messages it sends until the connection is closed
+func (t *TlsTransport) receiveFrom(conn *tls.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(TLS_DIAL_TIMEOUT * time.Millisecond))
+	if err := conn.Handshake(); err != nil {
+		t.logger.Warn("rejected connection", "remote", conn.RemoteAddr().String(), "error", err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	name := conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	port, known := nodePort(name)
+	if !known {
+		t.logger.Warn("rejected unknown peer", "remote", conn.RemoteAddr().String(), "name", name)
+		return
+	}
+	if !t.track(conn) {
+		return
+	}
+	defer t.untrack(conn)
+
+	for {
+		message, err := readFrame(conn)
+		if err != nil {
+			if err != io.EOF {
+				t.logger.Debug("connection closed", "peer", name, "error", err)
+			}
+			return
+		}
+		select {
+		case t.incoming <- UdpMessage{Message: message, FromPort: port}:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// sendTo sends the messages queued for a peer over a connection, which is dialed again after a failure
+func (t *TlsTransport) sendTo(port int, queue chan string) {
+	config := t.clientConfig.Clone()
+	config.ServerName = nodeName(port)
+	dialer := &net.Dialer{Timeout: TLS_DIAL_TIMEOUT * time.Millisecond}
+	var conn *tls.Conn
+	for {
+		select {
+		case message := <-queue:
+			if conn == nil {
+				dialed, err := tls.DialWithDialer(dialer, "tcp", nodeAddress(t.addresses, port), config)
+				if err != nil {
+					continue
+				}
+				conn = dialed
+			}
+			if err := writeFrame(conn, message); err != nil {
+				conn.Close()
+				conn = nil
+			}
+		case <-t.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (t *TlsTransport) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return false
+	default:
+		t.conns[conn] = true
+		return true
+	}
+}
+
+func (t *TlsTransport) untrack(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, conn)
+}
+
+// writeFrame writes a message prefixed by its length
+func writeFrame(w io.Writer, message string) error {
+	frame := make([]byte, 4+len(message))
+	binary.BigEndian.PutUint32(frame, uint32(len(message)))
+	copy(frame[4:], message)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a message written by writeFrame. It returns an error if the length exceeds TLS_MAX_FRAME_LENGTH.
+func readFrame(r io.Reader) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > TLS_MAX_FRAME_LENGTH {
+		return "", errors.New("frame of " + strconv.Itoa(int(length)) + " bytes exceeds limit")
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
+
+func certPath(dir string, name string) string {
+	return filepath.Join(dir, name+".pem")
+}
+
+func keyPath(dir string, name string) string {
+	return filepath.Join(dir, name+"-key.pem")
+}
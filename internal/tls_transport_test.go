@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// freeAddress returns an address on 127.0.0.1 with a port which is free at the time
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func newTestTlsTransport(t *testing.T, port int, certDir string, addresses map[int]string) *TlsTransport {
+	transport, err := NewTlsTransport(port, certDir, addresses, discardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+func TestTlsTransportDeliversMessages(t *testing.T) {
+	dir := t.TempDir()
+	if err := GenerateCertificates(dir, []int{7000}); err != nil {
+		t.Fatal(err)
+	}
+	_, clientPort, _ := net.SplitHostPort(freeAddress(t))
+	addresses := map[int]string{
+		STARTING_PORT: freeAddress(t),
+		7000:          "localhost:" + clientPort,
+	}
+	replica := newTestTlsTransport(t, STARTING_PORT, dir, addresses)
+	client := newTestTlsTransport(t, 7000, dir, addresses)
+
+	client.Send("request", STARTING_PORT)
+	if message, err := replica.RecieveWithTimeout(2 * time.Second); err != nil || message != (UdpMessage{Message: "request", FromPort: 7000}) {
+		t.Fatalf("replica received %+v, %v, want request from 7000", message, err)
+	}
+	replica.Send("response", 7000)
+	if message, err := client.RecieveWithTimeout(2 * time.Second); err != nil || message != (UdpMessage{Message: "response", FromPort: STARTING_PORT}) {
+		t.Fatalf("client received %+v, %v, want response from %d", message, err, STARTING_PORT)
+	}
+}
+
+func TestTlsTransportRejectsUnknownCertificates(t *testing.T) {
+	dir := t.TempDir()
+	if err := GenerateCertificates(dir, []int{7000}); err != nil {
+		t.Fatal(err)
+	}
+	// a certificate signed by the certificate authority of the cluster, but for a name which is not of a node
+	ca, caKey, err := loadCertificateAuthority(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, key, err := newCertificateTemplate("intruder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.DNSNames = []string{"intruder"}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	if err := writeCertificate(dir, nodeName(7001), template, ca, key, caKey); err != nil {
+		t.Fatal(err)
+	}
+	// certificates of another cluster
+	otherDir := t.TempDir()
+	if err := GenerateCertificates(otherDir, []int{7002}); err != nil {
+		t.Fatal(err)
+	}
+
+	addresses := map[int]string{
+		STARTING_PORT: freeAddress(t),
+		7000:          freeAddress(t),
+		7001:          freeAddress(t),
+		7002:          freeAddress(t),
+	}
+	// the replica on 8001 is mapped to the address of the replica on 8000, whose certificate does not match its name
+	addresses[STARTING_PORT+1] = addresses[STARTING_PORT]
+	replica := newTestTlsTransport(t, STARTING_PORT, dir, addresses)
+	client := newTestTlsTransport(t, 7000, dir, addresses)
+
+	tests := []struct {
+		name   string
+		sender *TlsTransport
+		port   int
+	}{
+		{name: "unknown name", sender: newTestTlsTransport(t, 7001, dir, addresses), port: STARTING_PORT},
+		{name: "other certificate authority", sender: newTestTlsTransport(t, 7002, otherDir, addresses), port: STARTING_PORT},
+		{name: "mismatched replica", sender: client, port: STARTING_PORT + 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.sender.Send("rejected", test.port)
+			// the message of a known sender is the only one received
+			client.Send("accepted", STARTING_PORT)
+			if message, err := replica.RecieveWithTimeout(2 * time.Second); err != nil || message != (UdpMessage{Message: "accepted", FromPort: 7000}) {
+				t.Fatalf("replica received %+v, %v, want accepted from 7000", message, err)
+			}
+			if message, err := replica.RecieveWithTimeout(500 * time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("replica received %+v, %v, want no message", message, err)
+			}
+		})
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	addresses, err := ParseAddresses("8000=10.0.0.1:8000,7000=client.example:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 2 || addresses[8000] != "10.0.0.1:8000" || addresses[7000] != "client.example:9000" {
+		t.Errorf("ParseAddresses = %v", addresses)
+	}
+	if address := nodeAddress(addresses, 8001); address != "127.0.0.1:"+strconv.Itoa(8001) {
+		t.Errorf("address of an unmapped node = %s, want 127.0.0.1:8001", address)
+	}
+	for _, spec := range []string{"8000", "x=10.0.0.1:8000", "8000=10.0.0.1", "70000=10.0.0.1:8000"} {
+		if _, err := ParseAddresses(spec); err == nil {
+			t.Errorf("ParseAddresses(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport sends & receives the messages of a node. Every node is addressed by its port.
+// UdpHandler sends every message as a datagram, while TlsTransport sends messages over mutually authenticated TLS streams.
+type Transport interface {
+	// Send sends a message to the node on a port
+	Send(message string, port int) error
+	// Receive waits for the next message
+	Receive() (UdpMessage, error)
+	// RecieveWithTimeout waits for the next message for a duration. It returns an error whose Timeout is true if
+	// no message is received in time.
+	RecieveWithTimeout(timeout time.Duration) (UdpMessage, error)
+	// SetAuthenticator sets the Authenticator used to sign sent messages
+	SetAuthenticator(auth *Authenticator)
+	// Close stops the transport, after which Receive returns an error
+	Close() error
+}
+
+// isReplicaPort returns true if a port belongs to a replica of the cluster configuration
+func isReplicaPort(port int) bool {
+	return port >= STARTING_PORT && port < STARTING_PORT+NUMBER_OF_NODES
+}
+
+// nodeName returns the name identifying the node on a port in its certificate, e.g. replica-8000 or client-7000
+func nodeName(port int) string {
+	if isReplicaPort(port) {
+		return REPLICA_NAME_PREFIX + strconv.Itoa(port)
+	}
+	return CLIENT_NAME_PREFIX + strconv.Itoa(port)
+}
+
+// nodePort returns the port of the node identified by a name. It returns false if the name is not of a replica in
+// the cluster configuration or of a client on a port outside of it.
+func nodePort(name string) (int, bool) {
+	prefix := REPLICA_NAME_PREFIX
+	if strings.HasPrefix(name, CLIENT_NAME_PREFIX) {
+		prefix = CLIENT_NAME_PREFIX
+	}
+	port, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	if err != nil || port <= 0 || port > 65535 || name != prefix+strconv.Itoa(port) {
+		return 0, false
+	}
+	if isReplicaPort(port) != (prefix == REPLICA_NAME_PREFIX) {
+		return 0, false
+	}
+	return port, true
+}
+
+var (
+	_ Transport = (*UdpHandler)(nil)
+	_ Transport = (*TlsTransport)(nil)
+)
+
+// ParseAddresses parses the addresses of nodes given as comma separated <port>=<host>:<port>, e.g.
+// 8000=10.0.0.1:8000,8001=10.0.0.2:8000. It returns an error if a port is not a number or an address has no port.
+func ParseAddresses(spec string) (map[int]string, error) {
+	addresses := make(map[int]string)
+	if spec == "" {
+		return addresses, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		node, address, found := strings.Cut(entry, "=")
+		port, err := strconv.Atoi(node)
+		if !found || err != nil || port <= 0 || port > 65535 {
+			return nil, errors.New("invalid node " + strconv.Quote(entry) + ", should be <port>=<host>:<port>")
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, err
+		}
+		addresses[port] = address
+	}
+	return addresses, nil
+}
+
+// nodeAddress returns the host:port at which the node on a port is reached, which is its port on 127.0.0.1 unless
+// it is mapped to another address
+func nodeAddress(addresses map[int]string, port int) string {
+	if address, exists := addresses[port]; exists {
+		return address
+	}
+	return "127.0.0.1:" + strconv.Itoa(port)
+}
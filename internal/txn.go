@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// txnCondition is a single comparison in the if clause of a transaction. Supported conditions are:
+// - eq key value: key exists & its value is equal to value
+// - exists key: key exists
+// - missing key: key does not exist
+// - version key n: version of key is n. Version of a key that does not exist is 0
+// - revision key n: key was last updated at revision n. Revision of a key that does not exist is 0
+type txnCondition struct {
+	kind  string
+	key   string
+	value string
+}
+
+// txnOperation is a single update in the then or else branch of a transaction. Supported operations are:
+// - put key value
+// - delete key
+type txnOperation struct {
+	kind  string
+	key   string
+	value string
+}
+
+type txn struct {
+	conditions []txnCondition
+	then       []txnOperation
+	otherwise  []txnOperation
+}
+
+// performTxn evaluates all the conditions of a transaction & applies the operations of the then branch if all
+// of them hold, else the operations of the else branch. The whole transaction is parsed before any operation is
+// applied, so an invalid transaction leaves the database untouched. It returns the name of the applied branch.
+func (db *Database) performTxn(splits []string) (string, error) {
+	t, err := parseTxn(splitTxnTokens(splits[1:]))
+	if err != nil {
+		return "", err
+	}
+
+	operations, branch := t.then, TXN_THEN
+	for _, condition := range t.conditions {
+		if !db.evaluate(condition) {
+			operations, branch = t.otherwise, TXN_ELSE
+			break
+		}
+	}
+	for _, operation := range operations {
+		switch operation.kind {
+		case "put":
+			db.put(operation.key, operation.value)
+		case "delete":
+			db.remove(operation.key)
+		}
+	}
+	return branch, nil
+}
+
+func (db *Database) evaluate(condition txnCondition) bool {
+	entry, exists := db.store.Get(condition.key)
+	switch condition.kind {
+	case "eq":
+		return exists && entry.value == condition.value
+	case "exists":
+		return exists
+	case "missing":
+		return !exists
+	case "version":
+		return strconv.Itoa(entry.version) == condition.value
+	case "revision":
+		return strconv.Itoa(entry.revision) == condition.value
+	}
+	return false
+}
+
+// splitTxnTokens separates TXN_SEPARATOR from the tokens it is attached to, e.g. "1;" becomes "1" & ";"
+func splitTxnTokens(tokens []string) []string {
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		for i, part := range strings.Split(token, TXN_SEPARATOR) {
+			if i > 0 {
+				result = append(result, TXN_SEPARATOR)
+			}
+			if part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+func parseTxn(tokens []string) (txn, error) {
+	t := txn{}
+	i := 0
+	if i < len(tokens) && tokens[i] == TXN_IF {
+		i += 1
+		for {
+			condition, next, err := parseTxnCondition(tokens, i)
+			if err != nil {
+				return txn{}, err
+			}
+			t.conditions = append(t.conditions, condition)
+			i = next
+			if i < len(tokens) && tokens[i] == TXN_AND {
+				i += 1
+				continue
+			}
+			break
+		}
+	}
+	if i >= len(tokens) || tokens[i] != TXN_THEN {
+		return txn{}, ErrInvalidDatabaseRequest
+	}
+	then, i, err := parseTxnOperations(tokens, i+1)
+	if err != nil {
+		return txn{}, err
+	}
+	t.then = then
+	if i < len(tokens) {
+		if tokens[i] != TXN_ELSE {
+			return txn{}, ErrInvalidDatabaseRequest
+		}
+		otherwise, next, err := parseTxnOperations(tokens, i+1)
+		if err != nil {
+			return txn{}, err
+		}
+		if next != len(tokens) {
+			return txn{}, ErrInvalidDatabaseRequest
+		}
+		t.otherwise = otherwise
+	}
+	return t, nil
+}
+
+// parseTxnCondition parses a condition starting at tokens[i] & returns it along with the index of the next token
+func parseTxnCondition(tokens []string, i int) (txnCondition, int, error) {
+	if i+1 >= len(tokens) {
+		return txnCondition{}, i, ErrInvalidDatabaseRequest
+	}
+	kind, key := tokens[i], tokens[i+1]
+	switch kind {
+	case "exists", "missing":
+		return txnCondition{kind: kind, key: key}, i + 2, nil
+	case "eq":
+		if i+2 >= len(tokens) {
+			return txnCondition{}, i, ErrInvalidDatabaseRequest
+		}
+		return txnCondition{kind: kind, key: key, value: tokens[i+2]}, i + 3, nil
+	case "version", "revision":
+		if i+2 >= len(tokens) {
+			return txnCondition{}, i, ErrInvalidDatabaseRequest
+		}
+		if _, err := strconv.Atoi(tokens[i+2]); err != nil {
+			return txnCondition{}, i, ErrInvalidDatabaseRequest
+		}
+		return txnCondition{kind: kind, key: key, value: tokens[i+2]}, i + 3, nil
+	}
+	return txnCondition{}, i, ErrInvalidDatabaseRequest
+}
+
+// parseTxnOperations parses operations separated by TXN_SEPARATOR starting at tokens[i] till the end of tokens
+// or TXN_ELSE. It returns them along with the index of the next token.
+func parseTxnOperations(tokens []string, i int) ([]txnOperation, int, error) {
+	operations := make([]txnOperation, 0)
+	for i < len(tokens) && tokens[i] != TXN_ELSE {
+		if i+1 >= len(tokens) {
+			return nil, i, ErrInvalidDatabaseRequest
+		}
+		kind, key := tokens[i], tokens[i+1]
+		switch kind {
+		case "put":
+			if i+2 >= len(tokens) || tokens[i+2] == TXN_SEPARATOR {
+				return nil, i, ErrInvalidDatabaseRequest
+			}
+			operations = append(operations, txnOperation{kind: kind, key: key, value: tokens[i+2]})
+			i += 3
+		case "delete":
+			operations = append(operations, txnOperation{kind: kind, key: key})
+			i += 2
+		default:
+			return nil, i, ErrInvalidDatabaseRequest
+		}
+		if i < len(tokens) && tokens[i] == TXN_SEPARATOR {
+			i += 1
+		} else if i < len(tokens) && tokens[i] != TXN_ELSE {
+			return nil, i, ErrInvalidDatabaseRequest
+		}
+	}
+	return operations, i, nil
+}
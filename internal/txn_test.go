@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTxnMovesValue(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "set from 10")
+	if branch := mustApply(t, db, "txn if exists from and missing to then put to 10; delete from else delete to"); branch != TXN_THEN {
+		t.Fatalf("applied the %s branch, want %s", branch, TXN_THEN)
+	}
+	if value := mustApply(t, db, "mget from to"); value != "from\nto 10" {
+		t.Errorf("mget from to = %q after the move", value)
+	}
+
+	// the move is not repeated once the source is gone
+	if branch := mustApply(t, db, "txn if exists from and missing to then put to 10; delete from else put moved true"); branch != TXN_ELSE {
+		t.Fatalf("applied the %s branch, want %s", branch, TXN_ELSE)
+	}
+	if value := mustApply(t, db, "mget from to moved"); value != "from\nto 10\nmoved true" {
+		t.Errorf("mget from to moved = %q after the else branch", value)
+	}
+}
+
+func TestTxnConditions(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "set a 1")
+	mustApply(t, db, "set a 2")
+	for txn, want := range map[string]string{
+		"txn then put c 3":                              TXN_THEN,
+		"txn if eq a 2 then put c 3":                    TXN_THEN,
+		"txn if eq a 1 then put c 3":                    TXN_ELSE,
+		"txn if eq z 1 then put c 3":                    TXN_ELSE,
+		"txn if version a 2 then put c 3":               TXN_THEN,
+		"txn if version a 1 then put c 3":               TXN_ELSE,
+		"txn if version z 0 then put c 3":               TXN_THEN,
+		"txn if exists a and missing z then put c 3":    TXN_THEN,
+		"txn if exists a and exists z then put c 3":     TXN_ELSE,
+		"txn if missing a and eq a 2 then put c 3":      TXN_ELSE,
+		"txn if eq a 2 and version a 2 then delete zzz": TXN_THEN,
+	} {
+		if branch := mustApply(t, db, txn); branch != want {
+			t.Errorf("%q applied the %s branch, want %s", txn, branch, want)
+		}
+	}
+}
+
+func TestSplitTxnTokens(t *testing.T) {
+	want := []string{"put", "a", "1", ";", "delete", "b"}
+	for _, tokens := range [][]string{
+		{"put", "a", "1", ";", "delete", "b"},
+		{"put", "a", "1;", "delete", "b"},
+		{"put", "a", "1", ";delete", "b"},
+		{"put", "a", "1;delete", "b"},
+	} {
+		if got := splitTxnTokens(tokens); !slices.Equal(got, want) {
+			t.Errorf("splitTxnTokens(%q) = %q, want %q", tokens, got, want)
+		}
+	}
+}
+
+func TestMalformedTxnLeavesDatabaseUntouched(t *testing.T) {
+	db := NewDatabase()
+	mustApply(t, db, "set a 1")
+	for _, txn := range []string{
+		"txn",
+		"txn put c 3",
+		"txn if then put c 3",
+		"txn if eq a then put c 3",
+		"txn if version a x then put c 3",
+		"txn if newer a 1 then put c 3",
+		"txn if exists a and then put c 3",
+		"txn then put c 3; put",
+		"txn then put c 3 delete a",
+		"txn then put c 3 ; get a",
+		"txn then put c 3 else put d",
+		"txn then put c 3 else put d 4 else put e 5",
+	} {
+		if _, err := applyNext(db, txn); err != ErrInvalidDatabaseRequest {
+			t.Errorf("Apply(%q) = %v, want %v", txn, err, ErrInvalidDatabaseRequest)
+		}
+	}
+	if value := mustApply(t, db, "mget a c"); value != "a 1\nc" {
+		t.Errorf("mget a c = %q after malformed transactions", value)
+	}
+}
@@ -1,15 +1,16 @@
 package internal
 
 import (
-	"bytes"
 	"net"
 	"strconv"
 	"time"
 )
 
-// UdpHandler is a wrapper on top of udp client used to send & receive messages
+// UdpHandler is a wrapper on top of udp client used to send & receive messages.
+// Messages are signed by its Authenticator before they are sent, while received messages are verified by the caller.
 type UdpHandler struct {
 	socket *net.UDPConn
+	auth   *Authenticator
 }
 
 // UdpMessage is a record that describes the contents of a message & port from which the message is sent
@@ -42,13 +43,13 @@ func NewUdpHandler(port int) (*UdpHandler, error) {
 // by parsing the incoming message. Else it returns an error
 func (u *UdpHandler) RecieveWithTimeout(timeout time.Duration) (UdpMessage, error) {
 	u.socket.SetReadDeadline(time.Now().Add(timeout))
-	data := make([]byte, 1024)
-	_, addr, err := u.socket.ReadFromUDP(data)
+	data := make([]byte, UDP_MAX_DATAGRAM_LENGTH)
+	n, addr, err := u.socket.ReadFromUDP(data)
 	if err != nil {
 		return UdpMessage{}, err
 	}
 
-	message := string(bytes.Trim(data, "\x00"))
+	message := string(data[:n])
 	return UdpMessage{
 		Message:  message,
 		FromPort: addr.Port,
@@ -57,13 +58,13 @@ func (u *UdpHandler) RecieveWithTimeout(timeout time.Duration) (UdpMessage, erro
 
 // Receive listens on the port for UdpHandler & returns a UdpMessage instance by parsing the incoming message
 func (u *UdpHandler) Receive() (UdpMessage, error) {
-	data := make([]byte, 1024)
-	_, addr, err := u.socket.ReadFromUDP(data)
+	data := make([]byte, UDP_MAX_DATAGRAM_LENGTH)
+	n, addr, err := u.socket.ReadFromUDP(data)
 	if err != nil {
 		return UdpMessage{}, err
 	}
 
-	message := string(bytes.Trim(data, "\x00"))
+	message := string(data[:n])
 	return UdpMessage{
 		Message:  message,
 		FromPort: addr.Port,
@@ -77,10 +78,15 @@ func (u *UdpHandler) Send(message string, port int) error {
 		return err
 	}
 
-	_, err = u.socket.WriteToUDP([]byte(message), clientAddr)
+	_, err = u.socket.WriteToUDP([]byte(u.auth.Seal(message)), clientAddr)
 	return err
 }
 
+// SetAuthenticator sets the Authenticator used to sign sent messages. A nil Authenticator sends messages unsigned.
+func (u *UdpHandler) SetAuthenticator(auth *Authenticator) {
+	u.auth = auth
+}
+
 // Close closes the UDP socket associated with UdpHandler instance
 func (u *UdpHandler) Close() error {
 	return u.socket.Close()
@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUdpHandlerReceivesWholeMessage(t *testing.T) {
+	sender, err := NewUdpHandler(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	receiver, err := NewUdpHandler(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	// a catchup response easily exceeds 1024 bytes
+	message := "catchup_response:1:" + strings.Repeat("set a 1-0-7000,", 300) + "set a 1-0-7000"
+	if err := sender.Send(message, receiver.socket.LocalAddr().(*net.UDPAddr).Port); err != nil {
+		t.Fatal(err)
+	}
+	received, err := receiver.RecieveWithTimeout(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Message != message {
+		t.Errorf("received %d bytes of a message of %d bytes", len(received.Message), len(message))
+	}
+}
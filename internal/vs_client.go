@@ -2,78 +2,223 @@ package internal
 
 import (
 	"bufio"
-	"fmt"
+	"context"
+	"io"
+	"log/slog"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // VsClient is a wraper struct that is responsible for:
 // - Reading input from user through System input
-// - Sending message through UDP protocol
+// - Sending message through its Transport
 // - Maintaining ClientState
+// - Printing results to System output in its output format
 type VsClient struct {
-	udp_handler *UdpHandler
-	reader      *bufio.Reader
-	state       *ClientState
+	transport Transport
+	reader    *bufio.Reader
+	output    io.Writer
+	format    string
+	timeout   time.Duration
+	state     *ClientState
+	auth      *Authenticator
+	logger    *slog.Logger
+	mu        sync.Mutex
 }
 
-// NewVsClient creates an instance of VsClient on a specified port.
-// It returns an error if the creation fails.
-func NewVsClient(port int) (*VsClient, error) {
-	udp_handler, err := NewUdpHandler(port)
-	if err != nil {
-		return nil, err
-	}
+// NewVsClient creates an instance of VsClient on a specified port which communicates through the given transport &
+// logs through the given logger. Messages are signed & verified with auth, unless it is nil.
+func NewVsClient(port int, transport Transport, logger *slog.Logger, auth *Authenticator) *VsClient {
+	transport.SetAuthenticator(auth)
 	reader := bufio.NewReader(os.Stdin)
 	return &VsClient{
-		udp_handler: udp_handler,
-		reader:      reader,
-		state:       NewClientState(port),
-	}, nil
+		transport: transport,
+		reader:    reader,
+		output:    os.Stdout,
+		format:    OUTPUT_FORMAT_TEXT,
+		timeout:   0,
+		state:     NewClientState(port),
+		auth:      auth,
+		logger:    logger.With("client", port),
+		mu:        sync.Mutex{},
+	}
+}
+
+// SetToken sets the token of the user the client acts as on a cluster with access control enabled
+func (client *VsClient) SetToken(token string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.state.SetToken(token)
 }
 
-// Start runs an infinite loop which performs following steps in order:
+// Start runs a loop which performs following steps in order until the input ends:
 // - Reads input from user
-// - Sends a message to leader node through UDP
+// - Sends a message to leader node
 // - Receives the response from leader
 // - Prints the response
-func (client *VsClient) Start() {
+// A watch request instead prints the changes it receives until the client is stopped. Empty lines are skipped.
+// It returns nil once the input ends & the error otherwise.
+func (client *VsClient) Start() error {
 	for {
-		// read user input
+		// read user input, the last line of which may not end with a newline
 		input, err := client.reader.ReadString('\n')
+		if operation := strings.TrimSpace(input); operation != "" {
+			client.run(operation, true)
+		}
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			panic(err)
+			return err
 		}
+	}
+}
+
+// Execute sends an operation to the leader node & waits for its response. It returns the value produced by the
+// operation, or an *OperationError if the operation failed. It is safe to call Execute from multiple goroutines.
+// A session is registered before the first operation. If the session has expired, ErrSessionExpired is returned
+// & a new session is registered by the next call.
+func (client *VsClient) Execute(operation string) (string, error) {
+	return client.ExecuteContext(context.Background(), operation)
+}
 
-		// send message to leader node
-		clientRequest := client.state.BuildClientRequest(input)
-		client.udp_handler.Send(clientRequest, client.state.GetLeaderPort())
+// ExecuteContext is Execute, which stops waiting for the response once ctx is done & returns the error of ctx.
+// The operation may still be committed afterwards.
+func (client *VsClient) ExecuteContext(ctx context.Context, operation string) (string, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 
-		// read response for message
-		client.receive(clientRequest)
+	if client.state.GetSessionId() == 0 {
+		if err := client.register(ctx); err != nil {
+			return "", err
+		}
+	}
+	response, err := client.send(ctx, client.state.BuildClientRequest(operation))
+	if err == ErrSessionExpired {
+		client.state.RecordSessionId(0)
 	}
+	return response, err
 }
 
-func (client *VsClient) receive(clientRequest string) {
-	message, err := client.udp_handler.RecieveWithTimeout(1 * time.Second)
+// register registers a new session with the cluster & records its id
+func (client *VsClient) register(ctx context.Context) error {
+	response, err := client.send(ctx, client.state.BuildRegisterRequest())
 	if err != nil {
-		// if timeout error
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			// broadcast to all nodes & receive
-			client.state.Broadcast(clientRequest, client.udp_handler)
-			// invoke receive
-			client.receive(clientRequest)
-		} else {
-			fmt.Println("[receive_error] ", err)
+		return err
+	}
+	sessionId, err := strconv.Atoi(response)
+	if err != nil {
+		return ErrMalformedResponse
+	}
+	client.logger.Debug("registered session", "session", sessionId)
+	client.state.RecordSessionId(sessionId)
+	return nil
+}
+
+// send sends a request to the leader node & waits for its response
+func (client *VsClient) send(ctx context.Context, clientRequest string) (string, error) {
+	client.transport.Send(clientRequest, client.state.GetLeaderPort())
+
+	// read response for message
+	return client.receive(ctx, clientRequest)
+}
+
+// receive waits for the response of a client request. If the leader does not respond in time, the request is
+// broadcast to all the replicas. Responses carrying another request number, e.g. a late response to an earlier request,
+// are dropped. It returns the decoded response, which is an *OperationError if the request failed, or the error of ctx
+// once it is done.
+func (client *VsClient) receive(ctx context.Context, clientRequest string) (string, error) {
+	requestNumber := clientRequest[strings.LastIndex(clientRequest, DELIMETER)+len(DELIMETER):]
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		timeout := 1 * time.Second
+		if deadline, exists := ctx.Deadline(); exists {
+			timeout = min(timeout, time.Until(deadline))
+		}
+		message, err := client.transport.RecieveWithTimeout(timeout)
+		if err != nil {
+			// if timeout error
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if err := ctx.Err(); err != nil {
+					return "", err
+				}
+				client.logger.Debug("leader timed out, broadcasting request", "leader", client.state.GetLeaderPort())
+				// broadcast to all nodes & receive
+				client.state.Broadcast(clientRequest, client.transport)
+				continue
+			}
+			return "", err
+		}
+		payload, err := client.auth.Open(message.Message)
+		if err != nil {
+			client.logger.Warn("rejected message", "from", message.FromPort, "error", err)
+			continue
+		}
+		parts := strings.SplitN(payload, DELIMETER, 4)
+		if parts[0] != SERVER_RESPONSE_PREFIX || len(parts) != 4 {
+			continue
+		}
+		viewNumber, _ := strconv.Atoi(parts[1])
+		client.state.RecordViewNumber(viewNumber)
+		if parts[2] != requestNumber {
+			client.logger.Debug("dropped response to another request", "request_number", parts[2], "want", requestNumber)
+			continue
+		}
+		return DecodeResult(parts[3])
+	}
+}
+
+// watch prints the changes sent by the leader for a registered watch. The response to the watch request carries the
+// revision at which the watch was registered. The watch is renewed every WATCH_RENEW_INTERVAL from the revision after
+// the last change received, which also registers it with a new leader after a view change without missing changes.
+func (client *VsClient) watch(request WatchRequest, response string) {
+	revision := request.FromRevision - 1
+	if fields := strings.Fields(response); len(fields) == 2 && request.FromRevision == 0 {
+		revision, _ = strconv.Atoi(strings.TrimPrefix(fields[1], REVISION_PREFIX))
+	}
+	// changes are sent again if a renewal races with a commit, so the changes of the latest revision are remembered
+	seen := make(map[string]bool)
+	renewAt := time.Now().Add(WATCH_RENEW_INTERVAL * time.Millisecond)
+	for {
+		message, err := client.transport.RecieveWithTimeout(time.Until(renewAt))
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				request.FromRevision = revision + 1
+				client.state.Broadcast(client.state.BuildClientRequest(request.String()), client.transport)
+				renewAt = time.Now().Add(WATCH_RENEW_INTERVAL * time.Millisecond)
+				continue
+			}
+			client.logger.Error("error while receiving watch event", "error", err)
+			return
+		}
+		payload, err := client.auth.Open(message.Message)
+		if err != nil {
+			client.logger.Warn("rejected message", "from", message.FromPort, "error", err)
+			continue
+		}
+		// responses to renewals are not printed
+		parts := strings.SplitN(payload, DELIMETER, 3)
+		if parts[0] != WATCH_EVENT_PREFIX {
+			continue
 		}
-	} else {
-		parts := strings.Split(message.Message, DELIMETER)
 		viewNumber, _ := strconv.Atoi(parts[1])
-		response := parts[2]
 		client.state.RecordViewNumber(viewNumber)
-		fmt.Println("[server_response] " + response)
+		eventRevision, _ := strconv.Atoi(strings.TrimPrefix(strings.Fields(parts[2])[0], REVISION_PREFIX))
+		if eventRevision < revision || seen[parts[2]] {
+			continue
+		}
+		if eventRevision > revision {
+			revision = eventRevision
+			seen = make(map[string]bool)
+		}
+		seen[parts[2]] = true
+		client.printWatchEvent(parts[2])
 	}
 }
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLeader answers the client requests sent to the leader of view 0 on a memoryNetwork with the result of respond,
+// unless respond returns false, until the test ends
+func fakeLeader(t *testing.T, network *memoryNetwork, respond func(operation string) (string, error, bool)) {
+	transport := network.transport(STARTING_PORT)
+	t.Cleanup(func() { transport.Close() })
+	go func() {
+		for {
+			message, err := transport.Receive()
+			if err != nil {
+				return
+			}
+			parts := strings.Split(message.Message, DELIMETER)
+			if len(parts) != 5 || parts[0] != CLIENT_REQUEST_PREFIX {
+				continue
+			}
+			if value, err, ok := respond(parts[3]); ok {
+				transport.Send(strings.Join([]string{SERVER_RESPONSE_PREFIX, "0", parts[4], EncodeResult(value, err)}, DELIMETER), message.FromPort)
+			}
+		}
+	}()
+}
+
+func newTestClient(t *testing.T, network *memoryNetwork) *VsClient {
+	transport := network.transport(STARTING_PORT + NUMBER_OF_NODES)
+	t.Cleanup(func() { transport.Close() })
+	return NewVsClient(STARTING_PORT+NUMBER_OF_NODES, transport, discardLogger(), nil)
+}
+
+func TestExecFailsOnceTimeoutPasses(t *testing.T) {
+	client := newTestClient(t, newMemoryNetwork())
+	output := &bytes.Buffer{}
+	client.output = output
+	client.SetOutputFormat(OUTPUT_FORMAT_JSON)
+	client.SetTimeout(100 * time.Millisecond)
+
+	// no replica is running, so the operation is never answered
+	done := make(chan bool)
+	go func() { done <- client.Exec("get a") }()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("Exec succeeded without a cluster")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Exec did not return once its timeout passed")
+	}
+	if !strings.Contains(output.String(), context.DeadlineExceeded.Error()) {
+		t.Errorf("printed %q, want the deadline error", output.String())
+	}
+}
+
+func TestReceiveDropsResponsesToOtherRequests(t *testing.T) {
+	network := newMemoryNetwork()
+	fakeLeader(t, network, func(operation string) (string, error, bool) {
+		if operation == REGISTER_REQUEST {
+			return "65536", nil, true
+		}
+		return operation, nil, true
+	})
+	client := newTestClient(t, network)
+	if _, err := client.Execute("get a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a late response to the earlier request arrives before the response to the next one
+	network.deliver(UdpMessage{Message: strings.Join([]string{SERVER_RESPONSE_PREFIX, "0", "0", EncodeResult("get a", nil)}, DELIMETER), FromPort: STARTING_PORT}, client.state.clientId)
+	response, err := client.Execute("get b")
+	if err != nil || response != "get b" {
+		t.Errorf("Execute = %q, %v, want the response to get b", response, err)
+	}
+}
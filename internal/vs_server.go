@@ -1,8 +1,10 @@
 package internal
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
 	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -10,157 +12,374 @@ import (
 )
 
 type bufferedRequest struct {
-	command       string
-	requestNumber int
-	clientPort    int
-	commitNumber  int
-	serverPort    int
+	command         string
+	requestNumber   int
+	clientPort      int
+	operationNumber int
+	commitNumber    int
+	serverPort      int
 }
 
 // VsServer is a struct used to communicate with client & peer nodes.
 // It is also responsible for maintaining the state associated with a replica
 type VsServer struct {
-	udpHandler    *UdpHandler
+	transport     Transport
 	state         *ServerState
 	database      *Database
+	acl           *AccessControl
 	serverTimeout *ServerTimeout
+	adminServer   *AdminServer
+	metrics       *Metrics
+	auth          *Authenticator
+	watches       *WatchHub
+	expiry        *ExpiryTracker
+	pause         *Pause
+	logger        *slog.Logger
 	requestBuffer []bufferedRequest
+	handlers      sync.WaitGroup
+	done          chan struct{}
+	stopOnce      sync.Once
 	mu            sync.Mutex
 }
 
-// NewVsServer creates an instance of VsServer on a given port.
-// It returns an error if the creation process fails.
-func NewVsServer(port int) (*VsServer, error) {
-	udpHandler, err := NewUdpHandler(port)
-	if err != nil {
-		return nil, err
-	}
+// NewVsServer creates an instance of VsServer on a given port which communicates through the given transport & logs
+// through the given logger. Messages are signed & verified with auth, unless it is nil.
+func NewVsServer(port int, transport Transport, logger *slog.Logger, auth *Authenticator) *VsServer {
+	transport.SetAuthenticator(auth)
 	rand.New(rand.NewSource(time.Now().UnixNano()))
 	timeoutInterval := rand.Intn(int(MAX_TIMEOUT)-int(MIN_TIMEOUT)) + int(MIN_TIMEOUT)
 	serverTimeout := NewServerTimeout(timeoutInterval)
+	state := NewServerState(port)
+	metrics := NewMetrics()
+	pause := NewPause()
 
 	return &VsServer{
-		udpHandler:    udpHandler,
-		state:         NewServerState(port),
+		transport:     transport,
+		state:         state,
 		database:      NewDatabase(),
+		acl:           NewAccessControl(),
 		serverTimeout: serverTimeout,
+		adminServer:   NewAdminServer(port+ADMIN_PORT_OFFSET, state, metrics, pause),
+		metrics:       metrics,
+		auth:          auth,
+		watches:       NewWatchHub(),
+		expiry:        NewExpiryTracker(),
+		pause:         pause,
+		logger:        logger.With("replica", state.replicaNumber, "port", port),
 		requestBuffer: make([]bufferedRequest, 0),
+		done:          make(chan struct{}),
 		mu:            sync.Mutex{},
-	}, nil
+	}
 }
 
-// Start runs an infinite loop where it listens on its port & then processes any messages that it receives.
-func (server *VsServer) Start() {
+// EnableFaultInjection lets the replica be paused through its admin endpoint, e.g. by the bench command.
+// The admin endpoint is not authenticated, so it is disabled by default.
+func (server *VsServer) EnableFaultInjection() {
+	server.adminServer.EnableFaultInjection()
+}
+
+// Start runs a loop where it listens on its port & then processes any messages that it receives.
+// The loop runs until either ctx is cancelled or Stop is invoked. Start then waits for the messages that are
+// being processed to finish, stops the timer & returns nil. If receiving a message fails for any other reason,
+// the server is stopped in the same way & the error is returned.
+func (server *VsServer) Start(ctx context.Context) error {
 	go server.serverTimer()
+	go server.expiryTimer()
+	go server.heartbeatTimer()
+	go func() {
+		if err := server.adminServer.Start(); err != nil {
+			server.stateLogger().Error("admin server stopped", "error", err)
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			server.Stop()
+		case <-server.done:
+		}
+	}()
+
+	var err error
 	for {
-		message, err := server.udpHandler.Receive()
-		if err != nil {
-			panic(err)
+		message, receiveErr := server.transport.Receive()
+		if receiveErr != nil {
+			if !server.isStopped() {
+				err = receiveErr
+				server.Stop()
+			}
+			break
+		}
+		// messages failing authentication are dropped before they are dispatched
+		payload, authErr := server.auth.Open(message.Message)
+		if authErr != nil {
+			server.metrics.MessagesRejected.Inc(authErr.(*AuthError).Reason)
+			server.stateLogger().Warn("rejected message", "from", message.FromPort, "error", authErr)
+			continue
 		}
-		go server.handleMessage(message)
+		message.Message = payload
+		server.handlers.Add(1)
+		go func() {
+			defer server.handlers.Done()
+			server.handleMessage(message)
+		}()
+	}
+
+	// drain in-flight messages before stopping the timer
+	server.handlers.Wait()
+	server.serverTimeout.Stop()
+	server.stateLogger().Info("server stopped")
+	return err
+}
+
+// Stop stops the server from accepting new messages by closing its socket & admin endpoint.
+// It returns immediately; Start returns once the messages being processed are drained. It is safe to call Stop more than once.
+func (server *VsServer) Stop() {
+	server.stopOnce.Do(func() {
+		close(server.done)
+		server.transport.Close()
+		server.adminServer.Close()
+	})
+}
+
+func (server *VsServer) isStopped() bool {
+	select {
+	case <-server.done:
+		return true
+	default:
+		return false
 	}
 }
 
 func (server *VsServer) handleMessage(message UdpMessage) {
-	fmt.Println("[received message] ", message.Message)
+	// a paused replica drops messages as if it had crashed
+	if server.pause.Active() {
+		return
+	}
+	server.stateLogger().Debug("received message", "from", message.FromPort, "message", message.Message)
 	parts := strings.Split(message.Message, DELIMETER)
 	msgType := parts[0]
-	if msgType == CLIENT_REQUEST_PREFIX {
-		if !server.isLeader() {
+	decoded, err := decodeMessage(message.Message)
+	if err != nil {
+		server.rejectMalformed(err.(*MessageError), parts, message.FromPort)
+		return
+	}
+	server.metrics.MessagesReceived.Inc(msgType)
+	if reason := server.rejectSender(msgType, parts, message.FromPort); reason != "" {
+		server.metrics.MessagesRejected.Inc(reason)
+		server.stateLogger().Warn("rejected message from unexpected sender", "from", message.FromPort, "type", msgType, "reason", reason)
+		return
+	}
+	switch m := decoded.(type) {
+	case *clientRequestMessage:
+		// the leader of a view which is yet to start must not order requests
+		if !server.isLeader() || server.state.GetStatus() != NORMAL {
 			return
 		}
-		server.handleClientRequest(parts[1], parts[2], message.FromPort)
-	} else if msgType == PREPARE_REQUEST_PREFIX {
-		viewNumber, _ := strconv.Atoi(parts[1])
-		requestNumber, _ := strconv.Atoi(parts[3])
-		port, _ := strconv.Atoi(parts[4])
-		operationNumber, _ := strconv.Atoi(parts[5])
-		commitNumber, _ := strconv.Atoi(parts[6])
-		server.handlePrepareRequest(viewNumber, parts[2], requestNumber, port, operationNumber, commitNumber, message.FromPort)
-	} else if msgType == PREPARE_RESPONSE_PREFIX {
-		viewNumber, _ := strconv.Atoi(parts[1])
-		operationNumber, _ := strconv.Atoi(parts[2])
-		port, _ := strconv.Atoi(parts[3])
-		replicaId, _ := strconv.Atoi(parts[4])
-		server.handlePrepareResponse(viewNumber, operationNumber, port, replicaId)
-	} else if msgType == COMMIT_MESSAGE_PREFIX {
-		viewNumber, _ := strconv.Atoi(parts[1])
-		requestNumber, _ := strconv.Atoi(parts[2])
-		port, _ := strconv.Atoi(parts[3])
-		server.handleCommitMessage(viewNumber, requestNumber, port)
-	} else if msgType == CATCHUP_REQUEST_PREFIX {
-		repOpNo, _ := strconv.Atoi(parts[1])
-		lagOpNo, _ := strconv.Atoi(parts[2])
-		server.handleCatchupMessage(repOpNo, lagOpNo, message.FromPort)
-	} else if msgType == CATCHUP_RESPONSE_PREFIX {
-		commitNo, _ := strconv.Atoi(parts[1])
-		backupLogs := strings.Split(parts[2], ",")
-		server.processBackupLogs(backupLogs, commitNo)
-	} else if msgType == START_VIEW_CHANGE_PREFIX {
-		updatedViewNumber, _ := strconv.Atoi(parts[1])
-		port := message.FromPort
-		server.processStartViewChangeMessage(updatedViewNumber, port)
-	} else if msgType == DO_VIEW_CHANGE_PREFIX {
-		server.processDoViewChangeMessage(message.Message, message.FromPort)
+		server.handleClientRequest(m.sessionId, m.token, m.command, m.requestNumber, message.FromPort)
+	case *prepareRequestMessage:
+		server.handlePrepareRequest(m.viewNumber, m.command, m.requestNumber, m.clientId, m.operationNumber, m.commitNumber, message.FromPort)
+	case *prepareResponseMessage:
+		// votes are counted by the sender rather than the replica named in the message
+		server.handlePrepareResponse(m.viewNumber, m.operationNumber, m.clientId, message.FromPort)
+	case *commitMessage:
+		server.handleCommitMessage(m.viewNumber, m.commitNumber, m.requestNumber, m.clientId, m.digest, message.FromPort)
+	case *catchupRequestMessage:
+		server.handleCatchupMessage(m.replicaOperationNumber, m.laggingOperationNumber, message.FromPort)
+	case *catchupResponseMessage:
+		server.metrics.CatchupBytes.Add("received", float64(len(message.Message)))
+		server.processBackupLogs(m.logs, m.commitNumber)
+	case *startViewChangeMessage:
+		server.processStartViewChangeMessage(m.viewNumber, message.FromPort)
+	case *doViewChange:
+		server.processDoViewChangeMessage(m, message.FromPort)
+	case *startViewMessage:
+		server.startNewView(m.operationNumber, m.viewNumber, m.commitNumber, m.logs)
+	}
+}
+
+// rejectMalformed counts & logs a message which failed to parse. The leader responds to a malformed client request
+// with the error of the offending field, along with its last field as the request number if it is a number, while any
+// other malformed message is dropped.
+func (server *VsServer) rejectMalformed(err *MessageError, parts []string, fromPort int) {
+	server.metrics.MessagesRejected.Inc(err.Reason)
+	server.stateLogger().Warn("rejected malformed message", "from", fromPort, "error", err)
+	if err.Type != CLIENT_REQUEST_PREFIX || isReplicaPort(fromPort) || !server.isLeader() {
+		return
+	}
+	response := ErrMalformedRequest
+	if err.Field == "session" {
+		response = ErrSessionRequired
+	} else if err.Field == "request_number" {
+		response = ErrNonNumericRequestNumber
+	}
+	reqNo, numberErr := strconv.Atoi(parts[len(parts)-1])
+	if numberErr != nil {
+		reqNo = -1
+	}
+	server.send(server.state.BuildClientResponse(reqNo, EncodeResult("", response)), fromPort)
+}
+
+// rejectSender returns the reason to reject a message if it did not come from an expected sender, or else "".
+// Messages between replicas must come from another member of the configuration & messages which only the primary
+// sends must come from the primary of the view they carry. Client requests must not come from a replica.
+func (server *VsServer) rejectSender(msgType string, parts []string, fromPort int) string {
+	if msgType == CLIENT_REQUEST_PREFIX {
+		if isReplicaPort(fromPort) {
+			return REJECT_REPLICA_CLIENT
+		}
+		return ""
+	}
+	if !isReplicaPort(fromPort) || fromPort == server.port() {
+		return REJECT_NON_MEMBER
+	}
+	viewIndex := -1
+	if msgType == PREPARE_REQUEST_PREFIX || msgType == COMMIT_MESSAGE_PREFIX {
+		viewIndex = 1
 	} else if msgType == START_VIEW_PREFIX {
-		operationNumber, _ := strconv.Atoi(parts[1])
-		viewNumber, _ := strconv.Atoi(parts[2])
-		commitNumber, _ := strconv.Atoi(parts[3])
-		logs := strings.Split(parts[4], ",")
-		server.startNewView(operationNumber, viewNumber, commitNumber, logs)
+		viewIndex = 2
+	}
+	if viewIndex > 0 {
+		if len(parts) <= viewIndex {
+			return REJECT_NOT_PRIMARY
+		}
+		viewNumber, err := strconv.Atoi(parts[viewIndex])
+		if err != nil || viewNumber < 0 || fromPort != server.state.configuration[viewNumber%NUMBER_OF_NODES] {
+			return REJECT_NOT_PRIMARY
+		}
 	}
+	return ""
 }
 
-func (server *VsServer) handleClientRequest(command string, currentRequestNumber string, port int) {
-	// validate request
-	reqNo, err := strconv.Atoi(currentRequestNumber)
+func (server *VsServer) handleClientRequest(clientId int, token string, command string, reqNo int, port int) {
+	// the user of the token must be permitted to access the keys of the request. Session operations other than
+	// register & the expiry of keys & leases are proposed by the leader only.
+	fields := strings.Fields(command)
+	err := server.acl.Authorize(token, command, server.database.LeaseKeys)
+	if err == nil && isLeaderOperation(fields) {
+		err = ErrPermissionDenied
+	}
 	if err != nil {
-		server.udpHandler.Send(SERVER_RESPONSE_PREFIX+DELIMETER+SERVER_RESPONSE_NON_NUMERIC_REQUEST_NUMBER, port)
+		server.metrics.ClientRequestsDenied.Inc(err.Error())
+		server.send(server.state.BuildClientResponse(reqNo, EncodeResult("", err)), port)
+		return
+	}
+	// watches are kept by the leader only & are not recorded in the log
+	if len(fields) > 0 && (fields[0] == WATCH_REQUEST || fields[0] == UNWATCH_REQUEST) {
+		server.handleWatchRequest(command, reqNo, port)
+		return
+	}
+	// a client registers without a session, in which case its port identifies it until it gets a session id
+	if clientId == 0 && (len(fields) != 1 || fields[0] != REGISTER_REQUEST) {
+		server.send(server.state.BuildClientResponse(reqNo, EncodeResult("", ErrSessionRequired)), port)
+		return
+	}
+	if clientId == 0 {
+		clientId = port
+	} else if !server.state.HasSession(clientId) {
+		server.send(server.state.BuildClientResponse(reqNo, EncodeResult("", ErrSessionExpired)), port)
 		return
 	}
 	// check the state of existing request in ClientTable for client
-	clientTableValue, exists := server.state.GetClientTableValue(port)
+	clientTableValue, exists := server.state.GetClientTableValue(clientId)
 	if exists {
 		// error for sending an already processed request number
 		if clientTableValue.RequestNumber > reqNo {
-			server.udpHandler.Send(SERVER_RESPONSE_PREFIX+DELIMETER+SERVER_RESPONSE_INVALID_REQUEST_NUMER, port)
+			server.send(server.state.BuildClientResponse(reqNo, EncodeResult("", ErrInvalidRequestNumber)), port)
 			return
 		}
 		if clientTableValue.RequestNumber == reqNo {
+			server.metrics.ClientRequestRetries.Inc("")
 			// send the processed response to client for the processed request
 			if clientTableValue.Response != "" {
-				server.udpHandler.Send(SERVER_RESPONSE_PREFIX+DELIMETER+clientTableValue.Response, port)
+				server.send(server.state.BuildClientResponse(reqNo, clientTableValue.Response), port)
+			} else if clientTableValue.Port == 0 {
+				// the request was received by a previous leader, respond once it is committed
+				server.state.RecordClientPort(clientId, port)
 			}
 			return
 		}
 	}
-	// Update client state
-	server.state.RecordRequest(command, reqNo, port)
-	server.state.InitializeVoteTable(port)
+	server.prepare(server.acl.PrepareOperation(command), reqNo, clientId, port)
+}
+
+// isLeaderOperation returns true for operations which only the leader proposes: session operations & the expiry of
+// keys & leases
+func isLeaderOperation(fields []string) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	return fields[0] == SESSION_REQUEST || fields[0] == "expire" || (fields[0] == "lease" && len(fields) > 1 && fields[1] == "expire")
+}
+
+// prepare records a new request of a client in the log & broadcasts it to the peer nodes for their vote.
+// The response is sent to port once the request is committed, unless port is 0.
+func (server *VsServer) prepare(command string, reqNo int, clientId int, port int) {
+	// concurrent requests are numbered & broadcast in the same order
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.metrics.StartTimer(commitTimerKey(clientId))
+	// Update client state & initialize the votes for its operation number
+	operationNumber := server.state.RecordRequest(command, reqNo, clientId, port)
+	server.state.InitializeVoteTable(operationNumber)
 
 	// Broadcast for vote
-	prepareRequest := server.state.BuildPrepareRequest(command, reqNo, port)
-	server.state.Broadcast(prepareRequest, server.udpHandler)
+	prepareRequest := server.state.BuildPrepareRequest(command, reqNo, clientId)
+	server.metrics.StartTimer(quorumTimerKey(clientId))
+	server.broadcast(prepareRequest)
+}
+
+// propose submits an operation originated by the leader itself through the normal prepare & commit path.
+// The replica's own port serves as the client, so at most one such operation is in flight at a time.
+// It returns false if the previous operation is yet to be committed.
+func (server *VsServer) propose(command string) bool {
+	port := server.port()
+	reqNo := 0
+	if clientTableValue, exists := server.state.GetClientTableValue(port); exists {
+		if clientTableValue.Response == "" {
+			return false
+		}
+		reqNo = clientTableValue.RequestNumber + 1
+	}
+	server.prepare(command, reqNo, port, 0)
+	return true
 }
 
 func (server *VsServer) handlePrepareRequest(viewNumber int, command string, requestNumber int, port int, operationNumber int, commitNumber int, fromPort int) {
-	if viewNumber < server.state.viewNumber {
+	if viewNumber < server.state.GetViewNumber() {
 		return
 	}
-	// view change has occurred
-	if viewNumber > server.state.viewNumber {
-		server.state.viewNumber = viewNumber
-	}
 	// reset timeout as we received a ping from leader replica
-	server.serverTimeout.Reset <- struct{}{}
+	server.serverTimeout.ResetTimeout()
+	// prepare requests which are handled concurrently must not be appended out of order or twice
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// a replica which missed the start of the view drops the operations it has not committed, as they may differ
+	// from the log of the new view, & catches up with the leader
+	if viewNumber > server.state.GetViewNumber() || server.state.GetStatus() == VIEW_CHANGE {
+		server.state.UpdateViewNumber(viewNumber)
+		server.state.TruncateLog(server.state.commitNumber)
+		server.requestBuffer = append(server.requestBuffer, bufferedRequest{
+			command:         command,
+			requestNumber:   requestNumber,
+			clientPort:      port,
+			operationNumber: operationNumber,
+			commitNumber:    commitNumber,
+			serverPort:      fromPort,
+		})
+		server.startCatchup(operationNumber, fromPort)
+		return
+	}
 	// if replica is in recovery state then add the request to buffer
 	if server.state.GetStatus() == RECOVERING {
 		buffReq := &bufferedRequest{
-			command:       command,
-			requestNumber: requestNumber,
-			clientPort:    port,
-			commitNumber:  commitNumber,
-			serverPort:    fromPort,
+			command:         command,
+			requestNumber:   requestNumber,
+			clientPort:      port,
+			operationNumber: operationNumber,
+			commitNumber:    commitNumber,
+			serverPort:      fromPort,
 		}
 		server.requestBuffer = append(server.requestBuffer, *buffReq)
 		return
@@ -168,132 +387,259 @@ func (server *VsServer) handlePrepareRequest(viewNumber int, command string, req
 
 	if operationNumber == server.state.operationNumber+1 {
 		// Update client state
-		server.state.RecordRequest(command, requestNumber, port)
+		server.state.RecordRequest(command, requestNumber, port, 0)
 		// Send a vote acknowledging the request processing
-		server.udpHandler.Send(server.state.BuildPrepareResponse(operationNumber, port), fromPort)
+		server.send(server.state.BuildPrepareResponse(operationNumber, port), fromPort)
+		// the prepare request carries the commit number of the leader
+		server.executeCommitted(commitNumber)
 	} else if operationNumber > server.state.operationNumber+1 {
-		// update state to catching up
-		server.state.UpdateStatus(RECOVERING)
-
 		// push request to request_buffer
 		buffReq := &bufferedRequest{
-			command:       command,
-			requestNumber: requestNumber,
-			clientPort:    port,
-			commitNumber:  commitNumber,
-			serverPort:    fromPort,
+			command:         command,
+			requestNumber:   requestNumber,
+			clientPort:      port,
+			operationNumber: operationNumber,
+			commitNumber:    commitNumber,
+			serverPort:      fromPort,
 		}
 		server.requestBuffer = append(server.requestBuffer, *buffReq)
 
-		// send catch up request to leader
-		server.udpHandler.Send(server.state.BuildCatchupRequest(operationNumber), fromPort)
+		// update state to catching up & send catch up request to leader
+		server.startCatchup(operationNumber, fromPort)
 	}
 }
 
-func (server *VsServer) handlePrepareResponse(viewNumber int, operationNumber int, port int, replicaId int) {
-	quorum := server.state.RecordPrepareResponse(port, replicaId)
+// handlePrepareResponse records the vote of a replica for an operation. A replica only votes for an operation once it
+// has every operation before it, so once an operation has a quorum of votes, it is committed along with every
+// operation before it, in the order of operation numbers.
+func (server *VsServer) handlePrepareResponse(viewNumber int, operationNumber int, clientId int, replicaId int) {
+	// votes cast in another view may be for a different operation at the same operation number
+	if viewNumber != server.state.GetViewNumber() || !server.isLeader() || server.state.GetStatus() != NORMAL {
+		return
+	}
+	quorum := server.state.RecordPrepareResponse(operationNumber, replicaId)
 	if quorum {
+		server.metrics.StopTimer(quorumTimerKey(clientId), server.metrics.QuorumWait)
 		// locking is required as we can get concurrent prepare response and we want to perform the commit & broadcast about it at most once
-		// Hence while checking the existing response & updating the response, locking allows only one request to go through the commit & broadcast phase
-		// When the next thread acquires the thread post commit, it will see the existing response as non-empty & return instead of performing duplicate commit & broadcast
+		// The next thread to acquire the lock finds the operations already committed & returns without a duplicate commit & broadcast
 		server.mu.Lock()
 		defer server.mu.Unlock()
 
-		// perform the operation
-		clientTableValue, _ := server.state.GetClientTableValue(port)
-		// Don't process request if it is already processed. These are lagging nodes which are late to respond to PrepareRequest
-		if clientTableValue.Response != "" {
+		if server.state.commitNumber >= operationNumber {
 			return
 		}
-
-		// perform commit
-		response := server.performServerOperation(clientTableValue.Request)
-		server.state.RecordCommit(port, response)
-
-		// send response to client
-		server.udpHandler.Send(server.state.BuildClientResponse(response), port)
+		for server.state.commitNumber < operationNumber {
+			_, reqNo, committedClientId := parseLogEntry(server.state.log[server.state.commitNumber])
+			clientTableValue, _ := server.state.GetClientTableValue(committedClientId)
+			response := server.commitLog(server.state.log[server.state.commitNumber])
+			server.metrics.StopTimer(commitTimerKey(committedClientId), server.metrics.PrepareCommitLatency)
+
+			// send response to client, unless the operation was proposed by the leader itself
+			if clientTableValue.RequestNumber == reqNo && clientTableValue.Port != 0 {
+				server.send(server.state.BuildClientResponse(reqNo, response), clientTableValue.Port)
+			}
+		}
 
 		// Broadcast about commit
-		commitMessage := server.state.BuildCommitMessage(clientTableValue.RequestNumber, port)
-		server.state.Broadcast(commitMessage, server.udpHandler)
+		commitMessage := server.state.BuildCommitMessage()
+		server.broadcast(commitMessage)
 	}
 }
 
-func (server *VsServer) handleCommitMessage(viewNumber int, requestNumber int, port int) {
-	if viewNumber != server.state.viewNumber {
+// handleCommitMessage executes the operations up to the commit number of the leader in order. The commit message
+// identifies the request at the commit number, which is compared with the log to detect a diverged log. A replica
+// which is missing operations or has diverged catches up from the leader. Once the operations are executed, the digest
+// of the leader is compared with the digest of the replica to detect a diverged state.
+func (server *VsServer) handleCommitMessage(viewNumber int, commitNumber int, requestNumber int, clientId int, digest string, fromPort int) {
+	if viewNumber != server.state.GetViewNumber() {
 		return
 	}
 	// reset timeout as we received a ping from leader replica
-	server.serverTimeout.Reset <- struct{}{}
+	server.serverTimeout.ResetTimeout()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
 
 	if server.state.GetStatus() != NORMAL {
 		return
 	}
 
-	clientTableValue, exists := server.state.GetClientTableValue(port)
-	if exists {
-		if clientTableValue.RequestNumber != requestNumber {
-			fmt.Printf("[commit_message_error] got out of range request number. got %d current %d\n", requestNumber, clientTableValue.RequestNumber)
+	if commitNumber > server.state.commitNumber {
+		if commitNumber > len(server.state.log) {
+			server.stateLogger().Warn("missing committed operations", "leader_commit", commitNumber)
+			server.startCatchup(commitNumber+1, fromPort)
 			return
 		}
-		// perform commit
-		response := server.performServerOperation(clientTableValue.Request)
-		server.state.RecordCommit(port, response)
+		if !server.state.LogEntryMatches(commitNumber, requestNumber, clientId) {
+			server.stateLogger().Error("log diverged from leader", "operation", commitNumber, "client", clientId, "request_number", requestNumber)
+			server.state.TruncateLog(server.state.commitNumber)
+			server.startCatchup(commitNumber+1, fromPort)
+			return
+		}
+		server.executeCommitted(commitNumber)
+	}
+
+	if ownDigest, exists := server.state.Digest(commitNumber); exists && ownDigest != digest {
+		server.metrics.DigestMismatches.Inc("")
+		server.stateLogger().Error("state diverged from leader, transferring state", "operation", commitNumber, "digest", ownDigest, "leader_digest", digest)
+		server.transferState(commitNumber, fromPort)
 	}
 }
 
+// transferState drops the state of the replica & rebuilds it by executing the log of the leader up to a commit number
+func (server *VsServer) transferState(commitNumber int, leaderPort int) {
+	server.state.ResetForStateTransfer()
+	server.database.Reset()
+	server.acl.Reset()
+	server.startCatchup(commitNumber+1, leaderPort)
+}
+
+// executeCommitted executes the operations in the log up to a commit number in the order of operation numbers
+func (server *VsServer) executeCommitted(commitNumber int) {
+	for server.state.commitNumber < commitNumber && server.state.commitNumber < len(server.state.log) {
+		server.commitLog(server.state.log[server.state.commitNumber])
+	}
+}
+
+// startCatchup moves the replica to recovering & requests the log up to the operation before operationNumber from the leader
+func (server *VsServer) startCatchup(operationNumber int, leaderPort int) {
+	server.state.UpdateStatus(RECOVERING)
+	server.metrics.StartTimer(RECOVERING)
+	server.send(server.state.BuildCatchupRequest(operationNumber), leaderPort)
+}
+
 func (server *VsServer) handleCatchupMessage(replicaOperationNumber int, laggingOperationNumber int, fromPort int) {
-	server.udpHandler.Send(server.state.BuildCatchupResponse(replicaOperationNumber, laggingOperationNumber), fromPort)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	catchupResponse := server.state.BuildCatchupResponse(replicaOperationNumber, laggingOperationNumber)
+	server.metrics.CatchupBytes.Add("sent", float64(len(catchupResponse)))
+	server.send(catchupResponse, fromPort)
 }
 
 func (server *VsServer) processBackupLogs(logs []string, commitNumber int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.state.GetStatus() != RECOVERING {
+		return
+	}
 	for _, log := range logs {
-		splits := strings.Split(log, LOG_DELIMETER)
-		reqNo, _ := strconv.Atoi(splits[1])
-		port, _ := strconv.Atoi(splits[2])
-		server.state.RecordRequest(splits[0], reqNo, port)
+		if log == "" {
+			continue
+		}
+		command, reqNo, clientId := parseLogEntry(log)
+		server.state.RecordRequest(command, reqNo, clientId, 0)
 	}
-	// process backed up requests
+	// process backed up requests which follow the log
+	sort.Slice(server.requestBuffer, func(i, j int) bool {
+		return server.requestBuffer[i].operationNumber < server.requestBuffer[j].operationNumber
+	})
 	for _, entry := range server.requestBuffer {
-		server.state.RecordRequest(entry.command, entry.requestNumber, entry.clientPort)
+		if entry.operationNumber == server.state.operationNumber+1 {
+			server.state.RecordRequest(entry.command, entry.requestNumber, entry.clientPort, 0)
+		}
 	}
 	// clear the buffer
 	server.requestBuffer = []bufferedRequest{}
 	// get updated on latest commit
-	for i := 0; i < commitNumber; i++ {
-		log := server.state.log[i]
-		server.commitLog(log)
-	}
+	server.executeCommitted(commitNumber)
 	// update status
 	server.state.UpdateStatus(NORMAL)
+	server.metrics.StopTimer(RECOVERING, server.metrics.RecoveryDuration)
+	// the votes for the operations which were buffered or caught up are cast by a single vote for the latest one
+	if server.state.operationNumber > server.state.commitNumber {
+		_, _, clientId := parseLogEntry(server.state.log[server.state.operationNumber-1])
+		server.send(server.state.BuildPrepareResponse(server.state.operationNumber, clientId), server.state.configuration[server.state.GetViewNumber()%NUMBER_OF_NODES])
+	}
 }
 
-func (server *VsServer) commitLog(log string) {
-	splits := strings.Split(log, LOG_DELIMETER)
-	command := splits[0]
-	reqNo, _ := strconv.Atoi(splits[1])
-	port, _ := strconv.Atoi(splits[2])
-	response := server.performServerOperation(command)
-	ctValue, _ := server.state.GetClientTableValue(port)
-	if ctValue.RequestNumber == reqNo {
-		server.state.RecordCommit(port, response)
-	} else {
-		server.state.IncrementCommitNumber()
-	}
+// commitLog executes the next operation to be committed & returns its response
+func (server *VsServer) commitLog(log string) string {
+	command, reqNo, clientId := parseLogEntry(log)
+	response := server.performServerOperation(command, clientId)
+	server.state.RecordCommit(clientId, reqNo, response)
+	return response
 }
 
-func (server *VsServer) performServerOperation(request string) string {
-	response := server.database.PerformOperation(request)
+// performServerOperation applies the next operation to be committed & notifies watchers of the changes it made.
+// Its revision is the operation number of its log entry. Session operations are applied on the client table &
+// acl operations on the users & roles instead. The leases of expired sessions are revoked by the same operation.
+func (server *VsServer) performServerOperation(request string, clientId int) string {
+	revision := server.state.commitNumber + 1
+	if isSessionOperation(request) {
+		response := server.state.ApplySessionOperation(request, revision)
+		if expired, err := parseSessionExpire(request); err == nil {
+			server.database.RevokeSessionLeases(expired, revision)
+			server.notifyWatchers(revision)
+		}
+		return response
+	}
+	if isAclOperation(request) {
+		return server.acl.Apply(request)
+	}
+	response := server.database.PerformOperation(request, revision, clientId)
+	server.notifyWatchers(revision)
 	return response
 }
 
+// handleWatchRequest registers, renews or removes the watch of a client. If the watch starts from a revision,
+// the retained changes since that revision are sent right after the response.
+func (server *VsServer) handleWatchRequest(command string, reqNo int, port int) {
+	if strings.Fields(command)[0] == UNWATCH_REQUEST {
+		server.watches.Unregister(port)
+		server.send(server.state.BuildClientResponse(reqNo, EncodeResult(UPDATE_PERFORMED_SUCCESSFULLY, nil)), port)
+		return
+	}
+	request, err := ParseWatchRequest(command)
+	if err != nil {
+		server.send(server.state.BuildClientResponse(reqNo, EncodeResult("", err)), port)
+		return
+	}
+
+	// commits on the leader happen while holding the lock, so no change is missed or sent twice between
+	// reading the retained changes & registering the watch
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	events := make([]ChangeEvent, 0)
+	if request.FromRevision > 0 {
+		events, err = server.database.Changes(request.FromRevision)
+		if err != nil {
+			server.send(server.state.BuildClientResponse(reqNo, EncodeResult("", err)), port)
+			return
+		}
+	}
+	server.watches.Register(port, request)
+	server.send(server.state.BuildClientResponse(reqNo, EncodeResult(WATCH_REGISTERED+" "+formatRevision(server.state.commitNumber), nil)), port)
+	for _, event := range events {
+		if request.Matches(event.Key) {
+			server.send(server.state.BuildWatchEvent(event), port)
+		}
+	}
+}
+
+// notifyWatchers sends the changes made at a revision to the clients watching the changed keys
+func (server *VsServer) notifyWatchers(revision int) {
+	events, _ := server.database.Changes(revision)
+	for _, event := range events {
+		for _, port := range server.watches.Watchers(event.Key) {
+			server.send(server.state.BuildWatchEvent(event), port)
+		}
+	}
+}
+
 func (server *VsServer) processStartViewChangeMessage(updatedViewNumber int, fromPort int) {
-	if updatedViewNumber >= server.state.viewNumber {
-		if updatedViewNumber > server.state.viewNumber {
-			server.state.viewNumber = updatedViewNumber
-			server.state.UpdateStatus(VIEW_CHANGE)
+	// the view has already started
+	if updatedViewNumber == server.state.GetViewNumber() && server.state.GetStatus() == NORMAL {
+		return
+	}
+	if updatedViewNumber >= server.state.GetViewNumber() {
+		if updatedViewNumber > server.state.GetViewNumber() {
+			server.state.StartViewChange(updatedViewNumber)
+			server.metrics.ViewChangesStarted.Inc("")
 			startViewChangeReq := server.state.BuildStartViewChangeRequest()
-			server.state.Broadcast(startViewChangeReq, server.udpHandler)
+			server.broadcast(startViewChangeReq)
 		}
 		majority := server.state.RecordViewChange(fromPort, updatedViewNumber)
 		if majority {
@@ -302,74 +648,233 @@ func (server *VsServer) processStartViewChangeMessage(updatedViewNumber int, fro
 	}
 }
 
+// initiateDoViewChange sends the state of the replica to the leader of the new view. The leader of the new view
+// records its own state instead, so that its log is taken into account along with the logs of the others.
 func (server *VsServer) initiateDoViewChange(viewNumber int) {
 	newLeaderPort := STARTING_PORT + viewNumber%NUMBER_OF_NODES
-	// Assuming that the next replica in order is alive, old view number
-	// will be one less than the updated view number.
-	// This is not necessarily true as the next replica in order can also fail in which case,
-	// the replica next to it will be elected as leader
-	oldViewNumber := viewNumber - 1
-	doViewChangeRequest := server.state.BuildDoViewChangeRequest(oldViewNumber, viewNumber)
-	server.udpHandler.Send(doViewChangeRequest, newLeaderPort)
+	doViewChangeRequest := server.state.BuildDoViewChangeRequest(viewNumber)
+	if newLeaderPort != server.port() {
+		server.send(doViewChangeRequest, newLeaderPort)
+		return
+	}
+	doViewChange, err := parseDoViewChange(strings.Split(doViewChangeRequest, DELIMETER))
+	if err != nil {
+		server.stateLogger().Error("invalid own do_view_change", "error", err)
+		return
+	}
+	server.processDoViewChangeMessage(doViewChange, newLeaderPort)
 }
 
-func (server *VsServer) processDoViewChangeMessage(message string, port int) {
+func (server *VsServer) processDoViewChangeMessage(doViewChange *doViewChange, port int) {
 	server.mu.Lock()
 	defer server.mu.Unlock()
 
-	if server.state.GetStatus() == NORMAL {
+	if server.state.GetStatus() == NORMAL || doViewChange.newViewNumber != server.state.GetViewNumber() {
 		return
 	}
 
-	majority := server.state.RecordDoViewChange(message, port)
+	majority := server.state.RecordDoViewChange(doViewChange, port)
 	if majority {
 		uncommitedLogs := server.state.UpdateForNewView()
-		// commit any pending logs
+		// commit any pending logs in order
 		for _, log := range uncommitedLogs {
 			server.commitLog(log)
 		}
+		server.state.ResetVoteTable()
+		server.state.RebuildClientTable()
 		// update status to normal
 		server.state.UpdateStatus(NORMAL)
+		server.metrics.ViewChangesCompleted.Inc("")
 		// broadcast start view message
 		startViewRequest := server.state.BuildStartViewRequest()
-		server.state.Broadcast(startViewRequest, server.udpHandler)
+		server.broadcast(startViewRequest)
 	}
 }
 
 func (server *VsServer) startNewView(operationNumber int, viewNumber int, commitNumber int, logs []string) {
-	server.state.UpdateView(operationNumber, viewNumber, commitNumber, logs)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// start_view of an earlier view or of a view which has already started is stale
+	if viewNumber < server.state.GetViewNumber() || (viewNumber == server.state.GetViewNumber() && server.state.GetStatus() == NORMAL) {
+		return
+	}
+	if server.state.GetStatus() == VIEW_CHANGE {
+		server.metrics.ViewChangesCompleted.Inc("")
+	}
+
+	server.state.UpdateView(operationNumber, viewNumber, logs)
+	// execute the operations committed in the new view
+	server.executeCommitted(commitNumber)
+	server.state.RebuildClientTable()
 	server.state.UpdateStatus(NORMAL)
-	server.serverTimeout.Reset <- struct{}{}
+	server.serverTimeout.ResetTimeout()
+	// vote for the operations which are yet to be committed, so that the new leader can commit them
+	if server.state.operationNumber > server.state.commitNumber {
+		_, _, clientId := parseLogEntry(server.state.log[server.state.operationNumber-1])
+		server.send(server.state.BuildPrepareResponse(server.state.operationNumber, clientId), STARTING_PORT+viewNumber%NUMBER_OF_NODES)
+	}
 }
 
 func (server *VsServer) serverTimer() {
 	for {
 		select {
 		case <-server.serverTimeout.Timeout.C:
-			if server.isLeader() {
-				server.serverTimeout.Reset <- struct{}{}
-			} else {
-				// perform view change
-				fmt.Println("[replica_error] leader server timed out")
-				if server.state.GetStatus() == NORMAL {
-					server.startViewChange()
-				}
+			// a paused replica starts over with a full timeout once it resumes
+			if server.pause.Active() {
+				server.serverTimeout.ResetTimeout()
+				continue
 			}
+			server.handleTimeout()
 		case <-server.serverTimeout.Reset:
 			server.serverTimeout.Timeout.Reset(time.Duration(server.serverTimeout.TimeoutInterval) * time.Millisecond)
+		case <-server.done:
+			return
+		}
+	}
+}
+
+// handleTimeout starts a view change on a backup in normal status which has not heard from the leader in time
+func (server *VsServer) handleTimeout() {
+	if server.isLeader() {
+		server.serverTimeout.ResetTimeout()
+		return
+	}
+	// perform view change
+	server.stateLogger().Warn("leader timed out", "leader", STARTING_PORT+server.state.GetViewNumber()%NUMBER_OF_NODES)
+	if server.state.GetStatus() == NORMAL {
+		server.startViewChange()
+	}
+}
+
+// heartbeatTimer periodically broadcasts the commit number & digest while the replica is the leader.
+// It keeps the backups from starting a view change while there are no client requests & lets them detect divergence.
+func (server *VsServer) heartbeatTimer() {
+	ticker := time.NewTicker(HEARTBEAT_INTERVAL * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !server.pause.Active() {
+				server.sendHeartbeat()
+			}
+		case <-server.done:
+			return
+		}
+	}
+}
+
+// sendHeartbeat broadcasts the commit number & digest if the replica is the leader in normal status
+func (server *VsServer) sendHeartbeat() {
+	if !server.isLeader() || server.state.GetStatus() != NORMAL {
+		return
+	}
+	server.mu.Lock()
+	commitMessage := server.state.BuildCommitMessage()
+	server.mu.Unlock()
+	server.broadcast(commitMessage)
+}
+
+// expiryTimer periodically proposes the expiry of keys, leases & client sessions whose ttl has passed while the replica is the leader
+func (server *VsServer) expiryTimer() {
+	ticker := time.NewTicker(EXPIRY_CHECK_INTERVAL * time.Millisecond)
+	defer ticker.Stop()
+	wasLeader := false
+	for {
+		select {
+		case <-ticker.C:
+			leader := server.isLeader() && server.state.GetStatus() == NORMAL && !server.pause.Active()
+			// ttls observed during an earlier term as leader are stale
+			if leader && !wasLeader {
+				server.expiry.Reset()
+			}
+			wasLeader = leader
+			if leader {
+				server.proposeExpiry()
+			}
+		case <-server.done:
+			return
+		}
+	}
+}
+
+// proposeExpiry proposes a single operation expiring up to EXPIRY_BATCH_SIZE keys, or else up to EXPIRY_BATCH_SIZE
+// client sessions, or else a single lease
+func (server *VsServer) proposeExpiry() {
+	expirables := append(server.database.Expirables(), server.state.ClientExpirables()...)
+	expired := server.expiry.Expired(expirables, time.Now())
+	keys := make([]string, 0)
+	for _, expirable := range expired {
+		if expirable.Key != "" && len(keys) < 2*EXPIRY_BATCH_SIZE {
+			keys = append(keys, expirable.Key, formatRevision(expirable.Revision))
+		}
+	}
+	if len(keys) > 0 {
+		server.propose("expire " + strings.Join(keys, " "))
+		return
+	}
+	sessions := make([]string, 0)
+	for _, expirable := range expired {
+		if expirable.ClientId != 0 && len(sessions) < EXPIRY_BATCH_SIZE {
+			sessions = append(sessions, strconv.Itoa(expirable.ClientId))
+		}
+	}
+	if len(sessions) > 0 {
+		server.propose(SESSION_REQUEST + " expire " + strings.Join(sessions, " "))
+		return
+	}
+	for _, expirable := range expired {
+		if expirable.LeaseId != 0 {
+			server.propose("lease expire " + strconv.Itoa(expirable.LeaseId))
+			return
 		}
 	}
 }
 
 func (server *VsServer) startViewChange() {
 	// update state for view change
-	server.state.viewNumber += 1
-	server.state.UpdateStatus(VIEW_CHANGE)
+	server.state.StartViewChange(server.state.GetViewNumber() + 1)
+	server.metrics.ViewChangesStarted.Inc("")
 	// Broadcast start view change request
 	startViewChangeReq := server.state.BuildStartViewChangeRequest()
-	server.state.Broadcast(startViewChangeReq, server.udpHandler)
+	server.broadcast(startViewChangeReq)
+}
+
+// port returns the port of the replica
+func (server *VsServer) port() int {
+	return server.state.configuration[server.state.replicaNumber]
 }
 
 func (server *VsServer) isLeader() bool {
-	return server.state.viewNumber%NUMBER_OF_NODES == server.state.replicaNumber
+	return server.state.GetViewNumber()%NUMBER_OF_NODES == server.state.replicaNumber
+}
+
+// stateLogger returns the server logger annotated with the current view, operation & commit numbers
+func (server *VsServer) stateLogger() *slog.Logger {
+	status := server.state.GetReplicaStatus()
+	return server.logger.With("view", status.ViewNumber, "op", status.OperationNumber, "commit", status.CommitNumber)
+}
+
+// send sends a message to a port & records it in the metrics
+func (server *VsServer) send(message string, port int) {
+	server.metrics.MessagesSent.Inc(messageType(message))
+	server.transport.Send(message, port)
+}
+
+// broadcast sends a message to all peer nodes & records it in the metrics
+func (server *VsServer) broadcast(message string) {
+	server.metrics.MessagesSent.Add(messageType(message), NUMBER_OF_NODES-1)
+	server.state.Broadcast(message, server.transport)
+}
+
+func messageType(message string) string {
+	return strings.SplitN(message, DELIMETER, 2)[0]
+}
+
+func commitTimerKey(clientPort int) string {
+	return "commit" + LOG_DELIMETER + strconv.Itoa(clientPort)
+}
+
+func quorumTimerKey(clientPort int) string {
+	return "quorum" + LOG_DELIMETER + strconv.Itoa(clientPort)
 }
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+)
+
+var benchmarkLogSizes = []int{100, 1000, 10000}
+
+// newLoggedSimulation creates a cluster whose replicas have committed size operations
+func newLoggedSimulation(b *testing.B, size int) *simulation {
+	sim := newSimulation(b)
+	for i := 0; i < size; i++ {
+		sim.execute(sim.clients[0], fmt.Sprintf("set key-%d %d", i%100, i))
+	}
+	return sim
+}
+
+// BenchmarkCommit measures a client request going through prepare, the quorum of votes & the commit on every replica
+func BenchmarkCommit(b *testing.B) {
+	sim := newSimulation(b)
+	client := sim.clients[0]
+	sim.execute(client, "set a 0")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sim.execute(client, "set a 1")
+	}
+}
+
+// BenchmarkCatchup measures a new replica catching up with a log of every size from the leader
+func BenchmarkCatchup(b *testing.B) {
+	for _, size := range benchmarkLogSizes {
+		b.Run(fmt.Sprintf("entries=%d", size), func(b *testing.B) {
+			sim := newLoggedSimulation(b, size)
+			lagging := NUMBER_OF_NODES - 1
+			port := STARTING_PORT + lagging
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				sim.servers[lagging].serverTimeout.Stop()
+				sim.servers[lagging] = NewVsServer(port, sim.network.transport(port), discardLogger(), nil)
+				b.StartTimer()
+				// the heartbeat of the leader carries a commit number beyond the log of the new replica
+				sim.servers[0].sendHeartbeat()
+				sim.deliverAll()
+				if state := sim.servers[lagging].state; state.commitNumber != sim.servers[0].state.commitNumber {
+					b.Fatalf("caught up to commit number %d of %d", state.commitNumber, sim.servers[0].state.commitNumber)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkViewChange measures a view change of replicas which have a log of every size. Each iteration moves
+// the cluster to the next view.
+func BenchmarkViewChange(b *testing.B) {
+	for _, size := range benchmarkLogSizes {
+		b.Run(fmt.Sprintf("entries=%d", size), func(b *testing.B) {
+			sim := newLoggedSimulation(b, size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				view := sim.servers[0].state.viewNumber + 1
+				for _, server := range sim.servers {
+					server.handleTimeout()
+				}
+				sim.deliverAll()
+				for j, server := range sim.servers {
+					if server.state.viewNumber != view || server.state.GetStatus() != NORMAL {
+						b.Fatalf("replica %d is in view %d with status %s, want view %d", j, server.state.viewNumber, server.state.GetStatus(), view)
+					}
+				}
+			}
+		})
+	}
+}
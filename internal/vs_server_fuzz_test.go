@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const maxSimulationSteps = 600
+
+var simulatedCommands = []string{
+	"set a 1",
+	"set b 2",
+	"incr a 1",
+	"append b x",
+	"delete a",
+	"get a",
+	"txn if exists a then put c 1 else put c 0",
+	"lease grant 30s",
+}
+
+// simulatedClient sends one request at a time & retries it until it gets a response, registering a session first
+type simulatedClient struct {
+	transport     *memoryTransport
+	sessionId     int
+	requestNumber int
+	command       string
+}
+
+// simulation runs a cluster of replicas on a memoryNetwork without their timers, so that the order in which messages
+// are delivered, dropped or duplicated & timeouts fire is decided by the input of a step
+type simulation struct {
+	t         testing.TB
+	network   *memoryNetwork
+	servers   []*VsServer
+	pending   [][]UdpMessage
+	clients   []*simulatedClient
+	views     []int
+	committed []string
+	forged    bool
+}
+
+// newSimulation creates a cluster along with a new oracle of invariants for builds with the vsrdebug tag
+func newSimulation(t testing.TB) *simulation {
+	resetInvariantOracle()
+	network := newMemoryNetwork()
+	sim := &simulation{
+		t:         t,
+		network:   network,
+		servers:   make([]*VsServer, NUMBER_OF_NODES),
+		pending:   make([][]UdpMessage, NUMBER_OF_NODES),
+		clients:   make([]*simulatedClient, 2),
+		views:     make([]int, NUMBER_OF_NODES),
+		committed: make([]string, 0),
+	}
+	for i := range sim.servers {
+		sim.servers[i] = NewVsServer(STARTING_PORT+i, network.transport(STARTING_PORT+i), discardLogger(), nil)
+	}
+	for i := range sim.clients {
+		sim.clients[i] = &simulatedClient{transport: network.transport(7000 + i)}
+	}
+	return sim
+}
+
+// step performs one action chosen by op on the replica or client chosen by target & arg, which are the first 3 bytes
+// of the input: a client (re)sends its request, a queued message is delivered, dropped or delivered while staying
+// queued, a replica times out, the leader sends a heartbeat or a message mutated by the rest of the input is injected.
+// It returns the number of bytes of the input taken by the step.
+func (sim *simulation) step(input []byte) int {
+	op, target, arg := input[0], input[1], input[2]
+	replica := int(target) % NUMBER_OF_NODES
+	queue := sim.pending[replica]
+	switch op % 7 {
+	case 0:
+		sim.sendClientRequest(sim.clients[int(target)%len(sim.clients)], int(arg)%NUMBER_OF_NODES, arg)
+	case 1, 2, 3:
+		if len(queue) == 0 {
+			return 3
+		}
+		index := int(arg) % len(queue)
+		message := queue[index]
+		if op%7 != 3 {
+			sim.pending[replica] = append(queue[:index:index], queue[index+1:]...)
+		}
+		if op%7 != 2 {
+			sim.servers[replica].handleMessage(message)
+		}
+	case 4:
+		sim.servers[replica].handleTimeout()
+	case 5:
+		sim.servers[replica].sendHeartbeat()
+	case 6:
+		return 3 + sim.inject(replica, arg, input[3:])
+	}
+	sim.collect()
+	sim.check()
+	return 3
+}
+
+// injectedMessages returns a message of every type a replica receives, as built by a replica at its current state
+func (sim *simulation) injectedMessages(sender int) []string {
+	state := sim.servers[sender].state
+	client := sim.clients[0]
+	return []string{
+		strings.Join([]string{CLIENT_REQUEST_PREFIX, strconv.Itoa(client.sessionId), "", "set a 1", strconv.Itoa(client.requestNumber + 1)}, DELIMETER),
+		state.BuildPrepareRequest("set a 1", client.requestNumber+1, client.transport.port),
+		state.BuildPrepareResponse(state.operationNumber, client.transport.port),
+		state.BuildCommitMessage(),
+		state.BuildCatchupRequest(state.operationNumber),
+		state.BuildCatchupResponse(0, state.operationNumber+1),
+		state.BuildStartViewChangeRequest(),
+		state.BuildDoViewChangeRequest(state.viewNumber + 1),
+		state.BuildStartViewRequest(),
+	}
+}
+
+// inject delivers a message of the type chosen by arg to a replica, after replacing one of its fields by bytes of
+// the input & possibly dropping the fields after it. The first byte of the input picks the field, with the high bit
+// set to truncate the message after it, & the second byte the number of bytes which make up the field. Protocol
+// messages are sent from a replica other than the receiver & client requests from a client. Replicas tolerate
+// crashes rather than forged messages, so once a mutated message is well-formed the invariants no longer hold & the
+// simulation ends. It returns the number of bytes of the input taken.
+func (sim *simulation) inject(replica int, arg byte, input []byte) int {
+	if len(input) < 2 {
+		return len(input)
+	}
+	sender := (replica + 1 + int(arg)%(NUMBER_OF_NODES-1)) % NUMBER_OF_NODES
+	messages := sim.injectedMessages(sender)
+	message := messages[int(arg/NUMBER_OF_NODES)%len(messages)]
+	fromPort := STARTING_PORT + sender
+	if strings.HasPrefix(message, CLIENT_REQUEST_PREFIX+DELIMETER) {
+		fromPort = sim.clients[0].transport.port
+	}
+
+	fields := strings.Split(message, DELIMETER)
+	field := int(input[0]&0x7f) % len(fields)
+	length := min(int(input[1])%16, len(input)-2)
+	fields[field] = string(input[2 : 2+length])
+	if input[0]&0x80 != 0 {
+		fields = fields[:field+1]
+	}
+	message = strings.Join(fields, DELIMETER)
+
+	if _, err := decodeMessage(message); err == nil {
+		resetInvariantOracle()
+		sim.forged = true
+	}
+	sim.servers[replica].handleMessage(UdpMessage{Message: message, FromPort: fromPort})
+	if !sim.forged {
+		sim.collect()
+		sim.check()
+	}
+	return 2 + length
+}
+
+func (sim *simulation) sendClientRequest(client *simulatedClient, replica int, arg byte) {
+	if client.sessionId == 0 {
+		client.command, client.requestNumber = REGISTER_REQUEST, 1
+	} else if client.command == "" {
+		client.command = simulatedCommands[int(arg)%len(simulatedCommands)]
+		client.requestNumber += 1
+	}
+	request := strings.Join([]string{CLIENT_REQUEST_PREFIX, strconv.Itoa(client.sessionId), "", client.command, strconv.Itoa(client.requestNumber)}, DELIMETER)
+	client.transport.Send(request, STARTING_PORT+replica)
+}
+
+// collect queues the messages sent to replicas & lets clients process their responses
+func (sim *simulation) collect() {
+	for i, server := range sim.servers {
+		sim.pending[i] = append(sim.pending[i], server.transport.(*memoryTransport).pending()...)
+	}
+	for _, client := range sim.clients {
+		for _, message := range client.transport.pending() {
+			parts := strings.SplitN(message.Message, DELIMETER, 4)
+			if len(parts) != 4 || parts[0] != SERVER_RESPONSE_PREFIX || parts[2] != strconv.Itoa(client.requestNumber) {
+				continue
+			}
+			value, err := DecodeResult(parts[3])
+			if err == ErrSessionExpired {
+				client.sessionId = 0
+			} else if client.command == REGISTER_REQUEST && err == nil {
+				client.sessionId, _ = strconv.Atoi(value)
+				client.requestNumber = -1
+			}
+			client.command = ""
+		}
+	}
+}
+
+// check asserts the invariants of every replica: the commit number does not exceed the operation number, the view
+// number never decreases, an operation number is never committed with a different entry, the database is the same as
+// the one produced by executing the committed log & replicas with the same commit number have byte-identical snapshots
+func (sim *simulation) check() {
+	snapshots := make(map[int][]byte)
+	for i, server := range sim.servers {
+		state := server.state
+		if state.commitNumber > state.operationNumber {
+			sim.t.Fatalf("replica %d: commit number %d exceeds operation number %d", i, state.commitNumber, state.operationNumber)
+		}
+		if state.viewNumber < sim.views[i] {
+			sim.t.Fatalf("replica %d: view number decreased from %d to %d", i, sim.views[i], state.viewNumber)
+		}
+		sim.views[i] = state.viewNumber
+		if state.commitNumber > len(state.log) {
+			sim.t.Fatalf("replica %d: commit number %d exceeds log length %d", i, state.commitNumber, len(state.log))
+		}
+		for op := 0; op < state.commitNumber; op++ {
+			if op == len(sim.committed) {
+				sim.committed = append(sim.committed, state.log[op])
+			} else if sim.committed[op] != state.log[op] {
+				sim.t.Fatalf("replica %d: committed %q at operation %d, which was committed as %q", i, state.log[op], op+1, sim.committed[op])
+			}
+		}
+		if replayed, actual := dumpDatabase(replayLog(state.log[:state.commitNumber])), dumpDatabase(server.database); replayed != actual {
+			sim.t.Fatalf("replica %d: database differs from replay of the committed log\nreplayed:\n%s\nactual:\n%s", i, replayed, actual)
+		}
+		snapshot := server.database.Snapshot()
+		if other, exists := snapshots[state.commitNumber]; exists && !bytes.Equal(snapshot, other) {
+			sim.t.Fatalf("replica %d: snapshot at commit number %d differs from the one of another replica", i, state.commitNumber)
+		}
+		snapshots[state.commitNumber] = snapshot
+	}
+}
+
+// replayLog executes the database operations of a log on a new Database
+func replayLog(log []string) *Database {
+	db := NewDatabase()
+	for i, entry := range log {
+		command, _, clientId := parseLogEntry(entry)
+		if expired, err := parseSessionExpire(command); err == nil {
+			db.RevokeSessionLeases(expired, i+1)
+		} else if !isSessionOperation(command) && !isAclOperation(command) {
+			db.PerformOperation(command, i+1, clientId)
+		}
+	}
+	return db
+}
+
+func dumpDatabase(db *Database) string {
+	sb := strings.Builder{}
+	db.store.Ascend("", func(key string, entry dbEntry) bool {
+		fmt.Fprintf(&sb, "key %s %+v\n", key, entry)
+		return true
+	})
+	for _, name := range sortedKeys(db.locks) {
+		fmt.Fprintf(&sb, "lock %s %+v\n", name, *db.locks[name])
+	}
+	fmt.Fprintf(&sb, "history %+v\nchanges %+v\nleases %+v\nrevision %d\n", db.history, db.changes, db.leases, db.revision)
+	return sb.String()
+}
+
+// runSimulation runs the steps of an input till a forged message was injected, of which only the first
+// maxSimulationSteps are taken so that the replay of the committed log after every step stays cheap
+func runSimulation(t *testing.T, input []byte) {
+	sim := newSimulation(t)
+	for i, steps := 0, 0; i+2 < len(input) && steps < maxSimulationSteps && !sim.forged; steps++ {
+		i += sim.step(input[i:])
+	}
+}
+
+// FuzzReplicaStateMachine runs a cluster through a sequence of steps, each of which is 3 bytes of the input
+func FuzzReplicaStateMachine(f *testing.F) {
+	for seed := int64(0); seed < 16; seed++ {
+		input := make([]byte, 900)
+		rand.New(rand.NewSource(seed)).Read(input)
+		f.Add(input)
+	}
+	// a client commits a few requests before a message of every type with a zeroed or truncated field is injected
+	// into a backup, sent by the primary
+	prefix := make([]byte, 0)
+	for request := 0; request < 4; request++ {
+		prefix = append(prefix, 0, 0, 0)
+		for round := 0; round < 10; round++ {
+			for replica := byte(0); replica < NUMBER_OF_NODES; replica++ {
+				prefix = append(prefix, 1, replica, 0)
+			}
+		}
+	}
+	for message := 0; message < 9; message++ {
+		arg := message * NUMBER_OF_NODES
+		for (1+1+arg%(NUMBER_OF_NODES-1))%NUMBER_OF_NODES != 0 {
+			arg++
+		}
+		for _, field := range []byte{1, 2, 0x80 | 1} {
+			input := append(slices.Clone(prefix), 6, 1, byte(arg), field, 1, '0')
+			f.Add(append(input, prefix...))
+		}
+	}
+	f.Fuzz(runSimulation)
+}
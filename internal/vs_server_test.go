@@ -0,0 +1,479 @@
+package internal
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// deliverAll delivers every queued message in the order it was sent, along with the messages sent in response,
+// until no message is left. Messages to held replicas stay queued.
+func (sim *simulation) deliverAll(held ...int) {
+	for {
+		sim.collect()
+		delivered := false
+		for i, server := range sim.servers {
+			if containsReplica(held, i) {
+				continue
+			}
+			queue := sim.pending[i]
+			sim.pending[i] = nil
+			for _, message := range queue {
+				server.handleMessage(message)
+				delivered = true
+			}
+		}
+		if !delivered {
+			return
+		}
+	}
+}
+
+// drop discards the messages queued for a replica, as if they were lost
+func (sim *simulation) drop(replica int) {
+	sim.collect()
+	sim.pending[replica] = nil
+}
+
+// deliverFrom delivers the queued messages of a replica which were sent by any of the senders, without delivering
+// the messages sent in response
+func (sim *simulation) deliverFrom(replica int, senders ...int) {
+	sim.collect()
+	queue := sim.pending[replica]
+	sim.pending[replica] = nil
+	for _, message := range queue {
+		if containsReplica(senders, message.FromPort-STARTING_PORT) {
+			sim.servers[replica].handleMessage(message)
+		} else {
+			sim.pending[replica] = append(sim.pending[replica], message)
+		}
+	}
+}
+
+// execute sends a command of a client to the primary of the latest view & delivers messages until the client has its
+// response, registering a session first. Messages to held replicas stay queued.
+func (sim *simulation) execute(client *simulatedClient, command string, held ...int) {
+	view := 0
+	for _, server := range sim.servers {
+		view = max(view, server.state.viewNumber)
+	}
+	leader := view % NUMBER_OF_NODES
+	if client.sessionId == 0 {
+		sim.sendClientRequest(client, leader, 0)
+		sim.deliverAll(held...)
+	}
+	client.command = command
+	client.requestNumber += 1
+	sim.sendClientRequest(client, leader, 0)
+	sim.deliverAll(held...)
+	if client.command != "" {
+		sim.t.Fatalf("no response to %q", command)
+	}
+}
+
+func containsReplica(replicas []int, replica int) bool {
+	for _, r := range replicas {
+		if r == replica {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPrimaryOfStartingViewIgnoresClientRequests(t *testing.T) {
+	sim := newSimulation(t)
+	client := sim.clients[0]
+	sim.execute(client, "set a 1")
+
+	// replica 1 moves to view 1, of which it is the primary, but the view has not started yet
+	sim.servers[1].handleTimeout()
+	client.command = "set a 2"
+	client.requestNumber += 1
+	sim.sendClientRequest(client, 1, 0)
+	sim.deliverAll(0, 2, 3, 4)
+
+	if state := sim.servers[1].state; state.operationNumber != 2 {
+		t.Errorf("primary of view %d with status %s ordered a request at operation %d", state.viewNumber, state.GetStatus(), state.operationNumber)
+	}
+}
+
+func TestPrimaryIgnoresVotesOfEarlierView(t *testing.T) {
+	sim := newSimulation(t)
+	client := sim.clients[0]
+	sim.execute(client, "set a 1")
+	for _, server := range sim.servers {
+		server.handleTimeout()
+	}
+	sim.deliverAll()
+
+	// the primary of view 1 prepares operation 3, for which its backups never vote
+	client.command = "set a 2"
+	client.requestNumber += 1
+	sim.sendClientRequest(client, 1, 0)
+	sim.deliverAll(0, 2, 3, 4)
+	// votes of view 0 for operation 3 arrive late
+	for _, port := range []int{STARTING_PORT + 2, STARTING_PORT + 3} {
+		sim.servers[1].handleMessage(UdpMessage{Message: "prepare_response:0:3:7000:" + strconv.Itoa(port), FromPort: port})
+	}
+
+	if state := sim.servers[1].state; state.commitNumber != 2 {
+		t.Errorf("primary of view %d committed up to operation %d with votes of view 0", state.viewNumber, state.commitNumber)
+	}
+}
+
+func TestDuplicateStartViewChangeCountsOnce(t *testing.T) {
+	sim := newSimulation(t)
+	sim.servers[2].handleTimeout()
+	sim.collect()
+	startViewChange := sim.pending[3][0]
+	sim.servers[3].handleMessage(startViewChange)
+	sim.servers[3].handleMessage(startViewChange)
+
+	sim.collect()
+	for _, message := range sim.pending[1] {
+		if strings.HasPrefix(message.Message, DO_VIEW_CHANGE_PREFIX) {
+			t.Fatalf("replica %d sent %q after a start_view_change of a single replica", message.FromPort-STARTING_PORT, message.Message)
+		}
+	}
+}
+
+func TestPrimaryOfNewViewKeepsItsCommittedOperations(t *testing.T) {
+	sim := newSimulation(t)
+	client := sim.clients[0]
+	sim.execute(client, "set a 1")
+	// operation 3 is committed by replicas 0, 1 & 2 only
+	sim.execute(client, "set a 2", 3, 4)
+	sim.drop(3)
+	sim.drop(4)
+
+	// replica 0 fails & the do_view_change messages of replicas 3 & 4, which lack operation 3, reach replica 1 first
+	for _, server := range sim.servers[1:] {
+		server.handleTimeout()
+	}
+	sim.drop(0)
+	sim.deliverFrom(3, 1, 2, 4)
+	sim.deliverFrom(4, 1, 2, 3)
+	sim.deliverFrom(1, 3, 4)
+	sim.deliverAll(0)
+
+	for i, server := range sim.servers[1:] {
+		if state := server.state; state.viewNumber != 1 || state.GetStatus() != NORMAL || state.operationNumber != 3 {
+			t.Errorf("replica %d is in view %d with status %s & operation number %d, want view 1 with status normal & operation number 3", i+1, state.viewNumber, state.GetStatus(), state.operationNumber)
+		}
+	}
+}
+
+func TestViewChangePrefersLogOfLatestNormalView(t *testing.T) {
+	sim := newSimulation(t)
+	sim.execute(sim.clients[0], "set a 0")
+	sim.execute(sim.clients[1], "set b 0")
+	// operations 5 & 6 of view 0 are prepared by replicas 0 & 4 only & never committed
+	for i, client := range sim.clients {
+		client.command = "set x " + strconv.Itoa(i)
+		client.requestNumber += 1
+		sim.sendClientRequest(client, 0, 0)
+		sim.deliverAll(1, 2, 3)
+	}
+	for _, replica := range []int{1, 2, 3} {
+		sim.drop(replica)
+	}
+	// view 1 starts without replicas 0 & 4 & commits another operation 5
+	for _, server := range sim.servers[1:4] {
+		server.handleTimeout()
+	}
+	sim.deliverAll(0, 4)
+	sim.execute(sim.clients[0], "set y 1", 0, 4)
+	sim.drop(0)
+	sim.drop(4)
+
+	// replica 1 fails & replica 4, which never had normal status in view 1, takes part in view 2
+	for _, server := range sim.servers[2:4] {
+		server.handleTimeout()
+	}
+	sim.drop(1)
+	sim.deliverAll(0, 1)
+
+	for i, server := range sim.servers[2:] {
+		state := server.state
+		if state.viewNumber != 2 || state.GetStatus() != NORMAL || state.operationNumber != 5 {
+			t.Errorf("replica %d is in view %d with status %s & operation number %d, want view 2 with status normal & operation number 5", i+2, state.viewNumber, state.GetStatus(), state.operationNumber)
+		} else if command, _, _ := parseLogEntry(state.log[4]); command != "set y 1" {
+			t.Errorf("replica %d has %q at operation 5, which was committed as \"set y 1\"", i+2, command)
+		}
+	}
+}
+
+func TestStaleStartViewIsIgnored(t *testing.T) {
+	sim := newSimulation(t)
+	sim.execute(sim.clients[0], "set a 1")
+	// view 1 starts while the messages to replica 4 are delayed
+	for _, server := range sim.servers[1:4] {
+		server.handleTimeout()
+	}
+	sim.deliverAll(4)
+	sim.collect()
+	var startView UdpMessage
+	for _, message := range sim.pending[4] {
+		if strings.HasPrefix(message.Message, START_VIEW_PREFIX+DELIMETER) {
+			startView = message
+		}
+	}
+	sim.drop(4)
+	// view 2 starts with replica 4, which then receives the start_view of view 1
+	for _, replica := range []int{0, 2, 3} {
+		sim.servers[replica].handleTimeout()
+	}
+	sim.deliverAll()
+	sim.servers[4].handleMessage(startView)
+
+	if state := sim.servers[4].state; state.viewNumber != 2 || state.GetStatus() != NORMAL {
+		t.Errorf("replica 4 is in view %d with status %s after a start_view of view 1, want view 2 with status normal", state.viewNumber, state.GetStatus())
+	}
+}
+
+func TestStaleDoViewChangeIsIgnored(t *testing.T) {
+	sim := newSimulation(t)
+	// replica 1 moves to view 6, of which it is the primary, & records its own do_view_change
+	for _, port := range []int{STARTING_PORT + 2, STARTING_PORT + 3} {
+		sim.servers[1].handleMessage(UdpMessage{Message: "start_view_change:6", FromPort: port})
+	}
+	// do_view_change messages of view 1 arrive late
+	for _, port := range []int{STARTING_PORT, STARTING_PORT + 4} {
+		sim.servers[1].handleMessage(UdpMessage{Message: "do_view_change:0:1:0:0:", FromPort: port})
+	}
+
+	if state := sim.servers[1].state; state.viewNumber != 6 || state.GetStatus() != VIEW_CHANGE {
+		t.Errorf("replica 1 is in view %d with status %s after do_view_change messages of view 1, want view 6 with status view change", state.viewNumber, state.GetStatus())
+	}
+}
+
+func TestBackupWhichMissedViewDropsUncommittedOperations(t *testing.T) {
+	sim := newSimulation(t)
+	sim.execute(sim.clients[0], "set a 0")
+	// operation 3 of view 0 is prepared by replicas 0 & 4 only & never committed
+	client := sim.clients[0]
+	client.command = "set x 1"
+	client.requestNumber += 1
+	sim.sendClientRequest(client, 0, 0)
+	sim.deliverAll(1, 2, 3)
+	for _, replica := range []int{1, 2, 3} {
+		sim.drop(replica)
+	}
+	// view 1 starts without replicas 0 & 4 & commits another operation 3
+	for _, server := range sim.servers[1:4] {
+		server.handleTimeout()
+	}
+	sim.deliverAll(0, 4)
+	sim.execute(sim.clients[1], "set y 1", 0, 4)
+	sim.drop(0)
+	sim.drop(4)
+
+	// replica 4 receives the prepare requests of view 1
+	sim.execute(sim.clients[1], "set z 1", 0)
+
+	leader, backup := sim.servers[1].state, sim.servers[4].state
+	if backup.viewNumber != 1 || backup.GetStatus() != NORMAL || strings.Join(backup.log, ",") != strings.Join(leader.log, ",") {
+		t.Errorf("replica 4 is in view %d with status %s & log %q, want view 1 with status normal & log %q", backup.viewNumber, backup.GetStatus(), backup.log, leader.log)
+	}
+}
+
+func TestConcurrentPreparesAreBroadcastInOrder(t *testing.T) {
+	sim := newSimulation(t)
+	leader := sim.servers[0]
+	// requests are handled concurrently by a goroutine each
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			leader.prepare("set a "+strconv.Itoa(i), 0, 7000+i, 0)
+		}(i)
+	}
+	wg.Wait()
+
+	// a backup receives the prepare requests in the order of their operation numbers
+	sim.collect()
+	for i, message := range sim.pending[1] {
+		decoded, err := decodeMessage(message.Message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prepare := decoded.(*prepareRequestMessage)
+		if prepare.operationNumber != i+1 {
+			t.Fatalf("prepare request %d has operation number %d", i+1, prepare.operationNumber)
+		}
+		if command, _, _ := parseLogEntry(leader.state.log[i]); command != prepare.command {
+			t.Fatalf("operation %d was prepared as %q, but is %q in the log", i+1, prepare.command, command)
+		}
+	}
+}
+
+func TestBackupVotesAfterCatchingUp(t *testing.T) {
+	sim := newSimulation(t)
+	sim.execute(sim.clients[0], "set a 0")
+	sim.execute(sim.clients[1], "set b 0")
+	for i, client := range sim.clients {
+		client.command = "set c " + strconv.Itoa(i)
+		client.requestNumber += 1
+		sim.sendClientRequest(client, 0, 0)
+	}
+	sim.deliverAll(1, 2, 3, 4)
+	// replicas 1 & 2 receive the prepare requests of operations 5 & 6 in reverse order & catch up, while the
+	// prepare requests to replicas 3 & 4 are lost
+	for _, replica := range []int{1, 2} {
+		queue := sim.pending[replica]
+		for i, j := 0, len(queue)-1; i < j; i, j = i+1, j-1 {
+			queue[i], queue[j] = queue[j], queue[i]
+		}
+	}
+	sim.drop(3)
+	sim.drop(4)
+	sim.deliverAll(3, 4)
+
+	if state := sim.servers[0].state; state.commitNumber != 6 {
+		t.Errorf("leader committed up to operation %d, want 6", state.commitNumber)
+	}
+}
+
+func TestLogEntriesWithDelimiterSurviveCatchupAndViewChange(t *testing.T) {
+	sim := newSimulation(t)
+	sim.execute(sim.clients[0], "set a x,y %2C")
+	// a new replica catches up with the log from the leader
+	sim.servers[4].serverTimeout.Stop()
+	sim.servers[4] = NewVsServer(STARTING_PORT+4, sim.network.transport(STARTING_PORT+4), discardLogger(), nil)
+	sim.servers[0].sendHeartbeat()
+	sim.deliverAll()
+	for _, server := range sim.servers {
+		server.handleTimeout()
+	}
+	sim.deliverAll()
+
+	for i, server := range sim.servers {
+		state := server.state
+		if state.viewNumber != 1 || state.GetStatus() != NORMAL || state.commitNumber != 2 {
+			t.Errorf("replica %d is in view %d with status %s & commit number %d, want view 1 with status normal & commit number 2", i, state.viewNumber, state.GetStatus(), state.commitNumber)
+		} else if command, _, _ := parseLogEntry(state.log[1]); command != "set a x,y %2C" {
+			t.Errorf("replica %d has %q at operation 2, want \"set a x,y %%2C\"", i, command)
+		}
+	}
+}
+
+func TestClientCannotProposeExpiry(t *testing.T) {
+	sim := newSimulation(t)
+	client := sim.clients[0]
+	sim.execute(client, "lease grant 30s")
+	for _, command := range []string{"expire a @1", "lease expire 2", "session expire 65537"} {
+		sim.execute(client, command)
+		if state := sim.servers[0].state; state.operationNumber != 2 {
+			t.Errorf("%q was recorded at operation %d", command, state.operationNumber)
+		}
+	}
+}
+
+// TestReplicaStatusDuringViewChange reads the status of a replica, as the admin endpoint does, while it changes views.
+// It is meant to be run with the race detector.
+func TestReplicaStatusDuringViewChange(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	sim := newSimulation(t)
+	server := sim.servers[1]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		view := 0
+		for i := 0; i < 1000; i++ {
+			status := server.state.GetReplicaStatus()
+			if status.ViewNumber < view {
+				t.Errorf("view number decreased from %d to %d", view, status.ViewNumber)
+				return
+			}
+			view = status.ViewNumber
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		server.state.UpdateStatus(NORMAL)
+		server.handleTimeout()
+		server.processStartViewChangeMessage(server.state.GetViewNumber()+1, STARTING_PORT)
+	}
+	<-done
+}
+
+func TestMessagesBuiltDuringViewChange(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	sim := newSimulation(t)
+	server := sim.servers[1]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if _, err := decodeMessage(server.state.BuildCommitMessage()); err != nil {
+				t.Errorf("built a malformed commit message: %v", err)
+				return
+			}
+			server.state.BuildClientResponse(i, EncodeResult(UPDATE_PERFORMED_SUCCESSFULLY, nil))
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		server.state.UpdateStatus(NORMAL)
+		server.handleTimeout()
+	}
+	<-done
+}
+
+func TestCatchupRequestForOperationZeroIsRejected(t *testing.T) {
+	sim := newSimulation(t)
+	client := sim.clients[0]
+	sim.execute(client, "set a 1")
+
+	sim.servers[0].handleMessage(UdpMessage{Message: "catchup_request:0:0", FromPort: STARTING_PORT + 1})
+	sim.collect()
+	if len(sim.pending[1]) != 0 {
+		t.Errorf("replica answered a catchup request for operation 0 with %q", sim.pending[1])
+	}
+	sim.execute(client, "set a 2")
+}
+
+func TestCatchupDuringPrepares(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	sim := newSimulation(t)
+	leader := sim.servers[0]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 200; i++ {
+			leader.handleMessage(UdpMessage{Message: "catchup_request:0:" + strconv.Itoa(i), FromPort: STARTING_PORT + 1})
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		leader.prepare("set a "+strconv.Itoa(i), i, 7000, 0)
+	}
+	<-done
+}
+
+func TestExpiredSessionReleasesItsLocks(t *testing.T) {
+	sim := newSimulation(t)
+	crashed, waiting := sim.clients[0], sim.clients[1]
+	sim.execute(crashed, "lease grant 30s")
+	lease := sim.servers[0].state.operationNumber
+	sim.execute(crashed, "lock acquire l "+strconv.Itoa(lease))
+
+	// the crashed client stops sending keepalives, so its session expires before its lease
+	sim.servers[0].propose(SESSION_REQUEST + " expire " + strconv.Itoa(crashed.sessionId))
+	sim.deliverAll()
+	sim.execute(waiting, "lease grant 30s")
+	lease = sim.servers[0].state.operationNumber
+	sim.execute(waiting, "lock acquire l "+strconv.Itoa(lease))
+	sim.servers[0].sendHeartbeat()
+	sim.deliverAll()
+
+	for i, server := range sim.servers {
+		if holders := server.database.lockHolders("l"); holders != strconv.Itoa(lease)+" "+strconv.Itoa(lease+1) {
+			t.Errorf("replica %d: holders of l are %q, want the lease %d of the waiting client", i, holders, lease)
+		}
+	}
+}
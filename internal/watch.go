@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatchRequest is a parsed watch operation: "watch key [@revision]" or "watch prefix* [@revision]".
+// FromRevision is 0 if the client is only interested in changes after the watch is registered.
+type WatchRequest struct {
+	Key          string
+	Prefix       bool
+	FromRevision int
+}
+
+// ParseWatchRequest parses a watch operation. It returns ErrInvalidDatabaseRequest if the operation is malformed.
+func ParseWatchRequest(operation string) (WatchRequest, error) {
+	splits := strings.Fields(operation)
+	if len(splits) < 2 || len(splits) > 3 || splits[0] != WATCH_REQUEST {
+		return WatchRequest{}, ErrInvalidDatabaseRequest
+	}
+	request := WatchRequest{Key: splits[1]}
+	if strings.HasSuffix(request.Key, WATCH_PREFIX_SUFFIX) {
+		request.Key = strings.TrimSuffix(request.Key, WATCH_PREFIX_SUFFIX)
+		request.Prefix = true
+	}
+	if len(splits) == 3 {
+		if !strings.HasPrefix(splits[2], REVISION_PREFIX) {
+			return WatchRequest{}, ErrInvalidDatabaseRequest
+		}
+		fromRevision, err := strconv.Atoi(splits[2][len(REVISION_PREFIX):])
+		if err != nil || fromRevision < 0 {
+			return WatchRequest{}, ErrInvalidDatabaseRequest
+		}
+		request.FromRevision = fromRevision
+	}
+	return request, nil
+}
+
+// String prepares the string representation of a watch request in the format accepted by ParseWatchRequest
+func (request WatchRequest) String() string {
+	result := WATCH_REQUEST + " " + request.Key
+	if request.Prefix {
+		result += WATCH_PREFIX_SUFFIX
+	}
+	if request.FromRevision > 0 {
+		result += " " + formatRevision(request.FromRevision)
+	}
+	return result
+}
+
+// Matches returns true if a change of key is of interest to the watch
+func (request WatchRequest) Matches(key string) bool {
+	if request.Prefix {
+		return strings.HasPrefix(key, request.Key)
+	}
+	return key == request.Key
+}
+
+type watcher struct {
+	request WatchRequest
+	renewed time.Time
+}
+
+// WatchHub keeps track of the watches registered by clients on the leader. Every client, identified by its
+// port, has at most one watch. Watches are not replicated; clients register them again with the new leader
+// after a view change.
+type WatchHub struct {
+	watchers map[int]watcher
+	mu       sync.Mutex
+}
+
+// NewWatchHub creates a new instance of WatchHub with no watches
+func NewWatchHub() *WatchHub {
+	return &WatchHub{
+		watchers: make(map[int]watcher),
+		mu:       sync.Mutex{},
+	}
+}
+
+// Register records or renews the watch of a client
+func (hub *WatchHub) Register(port int, request WatchRequest) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.watchers[port] = watcher{request: request, renewed: time.Now()}
+}
+
+// Unregister removes the watch of a client, if any
+func (hub *WatchHub) Unregister(port int) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	delete(hub.watchers, port)
+}
+
+// Watchers returns the ports of clients whose watch matches a key. Watches which have not been renewed
+// within WATCH_EXPIRY are dropped.
+func (hub *WatchHub) Watchers(key string) []int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	ports := make([]int, 0)
+	for port, w := range hub.watchers {
+		if time.Since(w.renewed) > WATCH_EXPIRY*time.Millisecond {
+			delete(hub.watchers, port)
+			continue
+		}
+		if w.request.Matches(key) {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
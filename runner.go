@@ -1,33 +1,200 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 	"vsrevisited/internal"
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		panic("need two arguments. Type(client/server) & a port")
+	logFormat := flag.String("log-format", internal.LOG_FORMAT_TEXT, "log output format: text or json")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	keyFile := flag.String("key-file", "", "file of shared keys used to sign & verify messages, one \"<key id> <secret>\" per line")
+	tlsDir := flag.String("tls-dir", "", "directory of certificates generated by the certs command. Messages are sent over mutually authenticated TLS instead of UDP")
+	addressSpec := flag.String("addresses", "", "comma separated <port>=<host>:<port> of the nodes which the TLS transport reaches at another address than their port on 127.0.0.1")
+	token := flag.String("token", "", "token of the user a client acts as on a cluster with access control enabled")
+	exec := flag.String("exec", "", "operation a client executes before it exits, with exit code 1 if the operation fails")
+	file := flag.String("file", "", "file of operations, one per line, a client executes before it prints a summary & exits, with exit code 1 if any operation fails")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long a client or an operation of the bench command waits for its response before it fails, 0 to wait forever or until the bench command ends")
+	output := flag.String("output", internal.OUTPUT_FORMAT_TEXT, "output format of a client: text or json")
+	clients := flag.Int("clients", 16, "number of concurrent clients of the bench command, which use consecutive ports from the given port")
+	duration := flag.Duration("duration", 30*time.Second, "how long the bench command sends operations")
+	rate := flag.Int("rate", 0, "operations per second sent by all the clients of the bench command, 0 for as many as they can")
+	readRatio := flag.Float64("reads", 0.5, "fraction of operations of the bench command which are reads, the others are writes")
+	keys := flag.Int("keys", 1000, "number of keys the bench command reads & writes")
+	distribution := flag.String("distribution", internal.BENCH_DISTRIBUTION_UNIFORM, "distribution of the keys of the bench command: uniform or zipfian")
+	valueSize := flag.Int("value-size", 16, "length of the values written by the bench command")
+	failEvery := flag.Duration("fail-every", 0, "interval at which the bench command pauses the leader to measure failover time, 0 to never fail")
+	failDuration := flag.Duration("fail-duration", 25*time.Second, "how long a leader paused by the bench command stays paused")
+	faultInjection := flag.Bool("fault-injection", false, "let a server be paused through POST /pause on its unauthenticated admin endpoint, as the bench command does with -fail-every")
+	args := parseArgs()
+
+	if len(args) == 1 && args[0] == "status" {
+		internal.PrintClusterStatus(os.Stdout, internal.FetchClusterStatus())
+		return
+	}
+	if len(args) >= 2 && args[0] == "certs" {
+		clientPorts := make([]int, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			port, err := strconv.Atoi(arg)
+			if err != nil {
+				exit(2, "client port should be an integer")
+			}
+			clientPorts = append(clientPorts, port)
+		}
+		if err := internal.GenerateCertificates(args[1], clientPorts); err != nil {
+			exit(1, "error while generating certificates: "+err.Error())
+		}
+		return
+	}
+	if len(args) != 2 {
+		exit(2, "need two arguments. Type(client/server/bench) & a port, certs & a directory followed by client ports or a single argument status")
 	}
-	t := os.Args[1]
-	port, err := strconv.Atoi(os.Args[2])
+	logger, err := internal.NewLogger(os.Stderr, *logFormat, *logLevel)
 	if err != nil {
-		panic("port should be an integer")
+		exit(2, "error while creating logger: "+err.Error())
 	}
-	if t == "client" {
-		client, err := internal.NewVsClient(port)
+	var auth *internal.Authenticator
+	if *keyFile != "" {
+		auth, err = internal.LoadAuthenticator(*keyFile)
 		if err != nil {
-			panic("error while creating new client: " + err.Error())
+			exit(1, "error while loading keys: "+err.Error())
 		}
-		client.Start()
-	} else if t == "server" {
-		server, err := internal.NewVsServer(port)
-		if err != nil {
-			panic("error while creating new server" + err.Error())
+	}
+	addresses, err := internal.ParseAddresses(*addressSpec)
+	if err != nil {
+		exit(2, "invalid addresses: "+err.Error())
+	}
+	t := args[0]
+	port, err := strconv.Atoi(args[1])
+	if err != nil {
+		exit(2, "port should be an integer")
+	}
+	if t != "client" && t != "server" && t != "bench" {
+		exit(2, "invalid type for runner")
+	}
+	if t == "bench" {
+		config := internal.BenchConfig{
+			Duration:     *duration,
+			Rate:         *rate,
+			ReadRatio:    *readRatio,
+			Keys:         *keys,
+			Distribution: *distribution,
+			ValueSize:    *valueSize,
+			FailEvery:    *failEvery,
+			FailDuration: *failDuration,
+			Timeout:      *timeout,
+		}
+		if err := config.Validate(); err != nil {
+			exit(2, "invalid bench configuration: "+err.Error())
+		}
+		if *clients < 1 {
+			exit(2, "number of clients should be at least 1")
+		}
+		benchClients := make([]*internal.VsClient, *clients)
+		for i := range benchClients {
+			clientTransport, err := newTransport(port+i, *tlsDir, addresses, logger)
+			if err != nil {
+				exit(1, "error while creating transport: "+err.Error())
+			}
+			benchClients[i] = internal.NewVsClient(port+i, clientTransport, logger, auth)
+			benchClients[i].SetToken(*token)
+		}
+		internal.PrintBenchReport(os.Stdout, internal.RunBench(benchClients, config))
+		return
+	}
+	transport, err := newTransport(port, *tlsDir, addresses, logger)
+	if err != nil {
+		exit(1, "error while creating transport: "+err.Error())
+	}
+	if t == "client" {
+		if *exec != "" && *file != "" {
+			exit(2, "exec & file cannot be used together")
+		}
+		if *output != internal.OUTPUT_FORMAT_TEXT && *output != internal.OUTPUT_FORMAT_JSON {
+			exit(2, "output format should be text or json")
+		}
+		client := internal.NewVsClient(port, transport, logger, auth)
+		client.SetToken(*token)
+		client.SetOutputFormat(*output)
+		client.SetTimeout(*timeout)
+		if *exec != "" {
+			if !client.Exec(*exec) {
+				os.Exit(1)
+			}
+		} else if *file != "" {
+			operations, err := internal.ReadOperations(*file)
+			if err != nil {
+				exit(1, "error while reading operations: "+err.Error())
+			}
+			if client.ExecBatch(operations) > 0 {
+				os.Exit(1)
+			}
+		} else if err := client.Start(); err != nil {
+			exit(1, "error while reading input: "+err.Error())
 		}
-		server.Start()
 	} else {
-		panic("invalid type for runner")
+		server := internal.NewVsServer(port, transport, logger, auth)
+		if *faultInjection {
+			server.EnableFaultInjection()
+		}
+		if auth != nil {
+			hangups := make(chan os.Signal, 1)
+			signal.Notify(hangups, syscall.SIGHUP)
+			go reloadKeys(auth, hangups, logger)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := server.Start(ctx); err != nil {
+			exit(1, "error while running server: "+err.Error())
+		}
+	}
+}
+
+// exit prints a message to stderr & exits with a code, which is 2 for invalid arguments & 1 for any other failure
+func exit(code int, message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(code)
+}
+
+// parseArgs parses the flags, which may come before, between or after the arguments, e.g.
+// "client -exec 'get a' 7000", & returns the arguments
+func parseArgs() []string {
+	args := make([]string, 0)
+	rest := os.Args[1:]
+	for {
+		flag.CommandLine.Parse(rest)
+		rest = flag.Args()
+		if len(rest) == 0 {
+			return args
+		}
+		args = append(args, rest[0])
+		rest = rest[1:]
+	}
+}
+
+// reloadKeys reloads the keys of a server from its key file every time it receives a signal
+func reloadKeys(auth *internal.Authenticator, signals <-chan os.Signal, logger *slog.Logger) {
+	for range signals {
+		if err := auth.Reload(); err != nil {
+			logger.Error("error while reloading keys, keeping the current keys", "error", err)
+			continue
+		}
+		logger.Info("reloaded keys")
+	}
+}
+
+// newTransport creates a TLS transport if a directory of certificates is given & a UDP transport otherwise
+func newTransport(port int, tlsDir string, addresses map[int]string, logger *slog.Logger) (internal.Transport, error) {
+	if tlsDir != "" {
+		return internal.NewTlsTransport(port, tlsDir, addresses, logger)
 	}
+	return internal.NewUdpHandler(port)
 }